@@ -16,15 +16,50 @@ package otlpr
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
 )
 
-const defaultMessages = 2048
+const (
+	defaultMessages     = 2048
+	defaultMaxQueueSize = 8
+
+	// severeTimeout is the minimum time waited for room in a full queue for
+	// a batch flushed because of FlushOnSeverity.
+	severeTimeout = 100 * time.Millisecond
+
+	// cleanupTimeout bounds the shutdown of a batcher that is no longer
+	// referenced by any logger.
+	cleanupTimeout = 30 * time.Second
+
+	// diskRetryInterval and diskRetryMaxInterval bound the time waited
+	// between attempts to export a batch from the disk queue.
+	diskRetryInterval    = time.Second
+	diskRetryMaxInterval = time.Minute
+)
+
+// QueuePolicy determines how a Batcher handles a completed batch of messages
+// when its queue of batches waiting to be exported is full.
+type QueuePolicy int
+
+const (
+	// DropNewest drops the completed batch.
+	DropNewest QueuePolicy = iota
+	// DropOldest drops the oldest batch in the queue to make room for the
+	// completed batch.
+	DropOldest
+	// Block waits for room in the queue. This blocks logging until room is
+	// available.
+	Block
+)
 
 type Batcher struct {
 	// Messages is the maximum number of messages to queue. Once this many
@@ -44,66 +79,461 @@ type Batcher struct {
 	// For values less than or equal to zero the Batcher will export the whole
 	// queue in a single export.
 	ExportN int
+	// MaxExportBytes is the maximum size, in bytes, of the protobuf encoded
+	// log records included in an export. Exports are split so they do not
+	// exceed this size. The resource and scope information included with
+	// each export adds to this size, the value should leave room for it below
+	// any limit enforced by the receiving endpoint (e.g. the default 4 MiB
+	// max gRPC message size).
+	//
+	// A single log record larger than MaxExportBytes is dropped and reported
+	// to the Options.ErrorHandler.
+	//
+	// For values less than or equal to zero the Batcher will not limit the
+	// size of an export.
+	MaxExportBytes int
+	// MaxQueueSize is the maximum number of completed batches of messages
+	// that can be waiting to be exported. Once this many batches are waiting
+	// the QueuePolicy determines how new batches are handled.
+	//
+	// If MaxQueueSize is less than or equal to zero, the default value of 8
+	// is used.
+	MaxQueueSize int
+	// QueuePolicy determines how a completed batch is handled when the queue
+	// is full. Messages that are dropped are counted in the DroppedLogRecords
+	// of the logger Stats.
+	//
+	// The default policy is DropNewest.
+	QueuePolicy QueuePolicy
+	// BlockTimeout is the maximum time to wait for room in a full queue when
+	// the QueuePolicy is Block. Once this much time has elapsed, the completed
+	// batch is dropped.
+	//
+	// If BlockTimeout is less than or equal to zero the Batcher will wait
+	// indefinitely.
+	BlockTimeout time.Duration
+	// Workers is the number of goroutines exporting completed batches
+	// concurrently. With more than one worker, batches may be exported out of
+	// order.
+	//
+	// If Workers is less than or equal to zero, a single worker is used.
+	Workers int
+	// FlushOnSeverity is the severity at or above which a message causes the
+	// queue, including that message, to be exported immediately. For example,
+	// lpb.SeverityNumber_SEVERITY_NUMBER_ERROR exports the queue whenever an
+	// error is logged.
+	//
+	// The export is still done asynchronously. Use Flush to wait for it to
+	// complete. If the queue is full, logging the message waits up to 100ms,
+	// or BlockTimeout if longer and the QueuePolicy is Block, for room in it.
+	// If there is still no room, the queue including the message is dropped
+	// and reported to the Options.ErrorHandler.
+	//
+	// If FlushOnSeverity is SEVERITY_NUMBER_UNSPECIFIED (the zero value) the
+	// Batcher will never export based on message severity.
+	FlushOnSeverity lpb.SeverityNumber
+	// DiskQueue configures a disk-backed queue for completed batches. When
+	// used, it replaces the in-memory queue and MaxQueueSize, QueuePolicy,
+	// and BlockTimeout have no effect.
+	//
+	// By default, the disk-backed queue is not used.
+	DiskQueue DiskQueue
 }
 
-type exportFunc func([]*lpb.LogRecord)
+// exportFunc exports log records. The passed context is canceled if the
+// batcher is shut down before the export completes. An error is returned if
+// the records were not delivered.
+type exportFunc func(context.Context, []record) error
 
 func chunk(n int, f exportFunc) exportFunc {
-	return func(lr []*lpb.LogRecord) {
+	return func(ctx context.Context, lr []record) error {
+		var errs []error
 		for i, j := 0, n; i < len(lr); i, j = i+n, j+n {
 			if j > len(lr) {
 				j = len(lr)
 			}
-			f(lr[i:j])
+			errs = append(errs, f(ctx, lr[i:j]))
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// recordSize returns the number of bytes r adds to the encoding of the log
+// records field of an export.
+func recordSize(r record) int {
+	// Field tag, length prefix, and message.
+	return 1 + protowire.SizeBytes(proto.Size(r.LogRecord))
+}
+
+// chunkBytes splits exports so the total recordSize of the records in each
+// is not greater than n. Any record larger than n on its own is passed to
+// drop instead of being exported.
+func chunkBytes(n int, f exportFunc, drop func(record, int)) exportFunc {
+	return func(ctx context.Context, lr []record) error {
+		var errs []error
+		var start, size int
+		for i, r := range lr {
+			rSize := recordSize(r)
+			if rSize > n {
+				if start < i {
+					errs = append(errs, f(ctx, lr[start:i]))
+				}
+				drop(r, rSize)
+				start, size = i+1, 0
+				continue
+			}
+			if size+rSize > n {
+				errs = append(errs, f(ctx, lr[start:i]))
+				start, size = i, 0
+			}
+			size += rSize
+		}
+		if start < len(lr) {
+			errs = append(errs, f(ctx, lr[start:]))
 		}
+		return errors.Join(errs...)
 	}
 }
 
-func (b Batcher) start(expFn exportFunc) *batcher {
+// start returns a running batcher that exports with expFn. If stopFn is not
+// nil, it is called once the batcher has been shut down. If errFn is not nil,
+// it is called with errors the batcher encounters.
+func (b Batcher) start(expFn exportFunc, stopFn func(context.Context) error, errFn func(error)) *batcher {
 	if b.Messages == 0 {
 		b.Messages = defaultMessages
 	}
+	if b.MaxQueueSize <= 0 {
+		b.MaxQueueSize = defaultMaxQueueSize
+	}
 	if expFn == nil {
-		expFn = func([]*lpb.LogRecord) {}
+		expFn = func(context.Context, []record) error { return nil }
+	}
+	if stopFn == nil {
+		stopFn = func(context.Context) error { return nil }
 	}
-	return newBatcher(b, expFn)
+	if errFn == nil {
+		errFn = func(error) {}
+	}
+	return newBatcher(b, expFn, stopFn, errFn)
+}
+
+// batcherRef is a reference to a batcher held by loggers. The goroutines of
+// the batcher do not reference it, so the batcher is shut down once no logger
+// references it. This releases the goroutines and exporter of loggers that
+// are never shut down.
+type batcherRef struct {
+	*batcher
+}
+
+// newBatcherRef returns a batcherRef for b. Once it is unreachable, b is shut
+// down with a context that is done after timeout. Exports, including the
+// retries of a disk queue, still in progress by then are canceled.
+func newBatcherRef(b *batcher, timeout time.Duration) *batcherRef {
+	r := &batcherRef{batcher: b}
+	runtime.AddCleanup(r, func(b *batcher) {
+		// Shutdown waits on exports, do not block other cleanups.
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			_ = b.Shutdown(ctx)
+		}()
+	}, b)
+	return r
 }
 
+// batcher queues appended messages into batches. Completed batches are sent
+// to background workers to be exported. This ensures appending a message
+// never waits on an export to complete.
 type batcher struct {
 	export exportFunc
+	stop   func(context.Context) error
+	err    func(error)
+
+	timeout       time.Duration
+	flushSeverity lpb.SeverityNumber
+	activeMu      sync.Mutex
+	active        *batch
+	appender      atomic.Value // func(record)
+
+	// queue holds completed batches waiting to be exported. It is only sent
+	// on, and closed, while holding activeMu.
+	queue        chan []record
+	policy       QueuePolicy
+	blockTimeout time.Duration
+	closed       bool
+	pending      *pending
+	dropped      atomic.Uint64
+	// disk, if not nil, is used instead of queue.
+	disk *diskQueue
+
+	// ctx is passed to all exports. It is canceled if a shutdown cannot wait
+	// for queued batches to be exported.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	pollWG     sync.WaitGroup
+	pollCancel context.CancelFunc
+	workerWG   sync.WaitGroup
 
-	timeout  time.Duration
-	activeMu sync.Mutex
-	active   *batch
-	appender atomic.Value // func(*lpb.LogRecord)
-
-	wg           sync.WaitGroup
-	cancel       context.CancelFunc
 	shutdownOnce sync.Once
 }
 
-func newBatcher(conf Batcher, expFn exportFunc) *batcher {
+func newBatcher(conf Batcher, expFn exportFunc, stopFn func(context.Context) error, errFn func(error)) *batcher {
+	b := &batcher{
+		timeout:       conf.Timeout,
+		flushSeverity: conf.FlushOnSeverity,
+		stop:          stopFn,
+		err:           errFn,
+		queue:         make(chan []record, conf.MaxQueueSize),
+		policy:        conf.QueuePolicy,
+		blockTimeout:  conf.BlockTimeout,
+		pending:       newPending(),
+	}
+
+	if conf.MaxExportBytes > 0 {
+		limit := conf.MaxExportBytes
+		expFn = chunkBytes(limit, expFn, func(_ record, size int) {
+			b.dropped.Add(1)
+			b.err(fmt.Errorf("otlpr: dropped log record: size %d exceeds MaxExportBytes %d", size, limit))
+		})
+	}
 	if conf.ExportN > 0 {
 		expFn = chunk(conf.ExportN, expFn)
 	}
+	b.export = expFn
 
-	b := &batcher{timeout: conf.Timeout, export: expFn}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	b.cancel = cancel
+	b.ctx, b.cancel = context.WithCancel(context.Background())
 	b.appender.Store(b.append)
 	b.active = newBatch(conf.Messages)
-	if conf.Timeout > 0 {
-		go b.poll(ctx)
+
+	if conf.DiskQueue.Dir != "" {
+		dq, n, err := openDiskQueue(conf.DiskQueue, func(batches, records int) {
+			b.dropped.Add(uint64(records))
+			b.pending.Add(-batches)
+		})
+		if err != nil {
+			b.err(fmt.Errorf("otlpr: failed to open disk queue, using in-memory queue: %w", err))
+		} else {
+			b.disk = dq
+			b.pending.Add(n)
+		}
+	}
+
+	workers := conf.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	b.workerWG.Add(workers)
+	for i := 0; i < workers; i++ {
+		if b.disk != nil {
+			go b.workDisk()
+		} else {
+			go b.work()
+		}
 	}
 
-	runtime.SetFinalizer(b, (*batcher).Shutdown)
+	var pollCtx context.Context
+	pollCtx, b.pollCancel = context.WithCancel(context.Background())
+	if conf.Timeout > 0 {
+		b.pollWG.Add(1)
+		go b.poll(pollCtx)
+	}
 	return b
 }
 
+// work exports queued batches until the queue is closed. Batches still
+// queued once the batcher is canceled are dropped.
+func (b *batcher) work() {
+	defer b.workerWG.Done()
+	for batch := range b.queue {
+		if b.ctx.Err() != nil {
+			b.drop(batch)
+			continue
+		}
+		// Export errors are reported by the exportFunc itself.
+		_ = b.export(b.ctx, batch)
+		b.pending.Add(-1)
+	}
+}
+
+// workDisk exports batches from the disk queue until it is closed or the
+// batcher is canceled.
+//
+// Batches that fail to export with a transient error are retried until they
+// are exported, keeping them on disk while the receiving endpoint is
+// unavailable. Batches left on disk when the batcher is canceled are replayed
+// when the next batcher using the same directory is started.
+func (b *batcher) workDisk() {
+	defer b.workerWG.Done()
+	for b.ctx.Err() == nil {
+		batch, id, ok := b.disk.Next()
+		if !ok {
+			return
+		}
+
+		delay := diskRetryInterval
+		for {
+			err := b.export(b.ctx, batch)
+			if err == nil || !transient(err) {
+				// Errors that are not transient will not succeed if retried.
+				// They have already been reported, drop the batch.
+				b.disk.Ack(id)
+				break
+			}
+
+			t := time.NewTimer(delay)
+			select {
+			case <-b.ctx.Done():
+				t.Stop()
+				b.pending.Add(-1)
+				return
+			case <-t.C:
+			}
+			if delay *= 2; delay > diskRetryMaxInterval {
+				delay = diskRetryMaxInterval
+			}
+		}
+		b.pending.Add(-1)
+	}
+}
+
+// transient returns if err is from an export that failed due to a transient
+// condition.
+func transient(err error) bool {
+	if ok, _ := retryable(err); ok {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// enqueue sends the active batch to be exported if it is not empty. If the
+// queue is full, the batch is handled based on the QueuePolicy. The activeMu
+// needs to be held when calling this.
+func (b *batcher) enqueue() {
+	if b.closed || b.active.Len() == 0 {
+		return
+	}
+	batch := b.active.Flush()
+	if b.disk != nil {
+		b.push(batch)
+		return
+	}
+	b.pending.Add(1)
+
+	switch b.policy {
+	case Block:
+		if b.blockTimeout <= 0 {
+			b.queue <- batch
+			return
+		}
+
+		t := time.NewTimer(b.blockTimeout)
+		defer t.Stop()
+		select {
+		case b.queue <- batch:
+		case <-t.C:
+			b.drop(batch)
+		}
+	case DropOldest:
+		for {
+			select {
+			case b.queue <- batch:
+				return
+			default:
+			}
+
+			// Only the holder of activeMu sends to the queue, there will be
+			// room once the oldest batch is removed.
+			select {
+			case old := <-b.queue:
+				b.drop(old)
+			default:
+			}
+		}
+	default:
+		select {
+		case b.queue <- batch:
+		default:
+			b.drop(batch)
+		}
+	}
+}
+
+// enqueueSevere sends the active batch, flushed because it contains a severe
+// message, to be exported. Unlike enqueue, it waits a bounded time for room in
+// a full queue regardless of the QueuePolicy. If the batch is dropped, it is
+// reported to the error handler. The activeMu needs to be held when calling
+// this.
+func (b *batcher) enqueueSevere() {
+	if b.disk != nil || b.policy == DropOldest || (b.policy == Block && b.blockTimeout <= 0) {
+		// The batch is never dropped.
+		b.enqueue()
+		return
+	}
+	if b.closed || b.active.Len() == 0 {
+		return
+	}
+	batch := b.active.Flush()
+	b.pending.Add(1)
+
+	timeout := severeTimeout
+	if b.policy == Block {
+		timeout = max(timeout, b.blockTimeout)
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case b.queue <- batch:
+	case <-t.C:
+		b.drop(batch)
+		b.err(fmt.Errorf("otlpr: export queue is full, dropped %d log records including a severe record", len(batch)))
+	}
+}
+
+// enqueueWait sends the active batch to be exported if it is not empty. It
+// waits for room in the queue regardless of the QueuePolicy, unless ctx is
+// done in which case the batch is dropped. The activeMu needs to be held
+// when calling this.
+func (b *batcher) enqueueWait(ctx context.Context) {
+	if b.closed || b.active.Len() == 0 {
+		return
+	}
+	batch := b.active.Flush()
+	if b.disk != nil {
+		b.push(batch)
+		return
+	}
+	b.pending.Add(1)
+
+	select {
+	case b.queue <- batch:
+	case <-ctx.Done():
+		b.drop(batch)
+	}
+}
+
+// push appends batch to the disk queue.
+func (b *batcher) push(batch []record) {
+	b.pending.Add(1)
+	if err := b.disk.Push(batch); err != nil {
+		b.drop(batch)
+		b.err(fmt.Errorf("otlpr: failed to write to disk queue: %w", err))
+	}
+}
+
+// drop discards a batch that was added as pending.
+func (b *batcher) drop(batch []record) {
+	b.dropped.Add(uint64(len(batch)))
+	b.pending.Add(-1)
+}
+
+// Dropped returns the number of messages dropped because the queue was full.
+func (b *batcher) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
 func (b *batcher) poll(parent context.Context) {
-	b.wg.Add(1)
-	defer b.wg.Done()
+	defer b.pollWG.Done()
 
 	timestamp := time.Now()
 	reset := func() time.Time {
@@ -114,7 +544,7 @@ func (b *batcher) poll(parent context.Context) {
 			return ts
 		}
 
-		b.export(b.active.Flush())
+		b.enqueue()
 		return time.Now()
 	}
 
@@ -137,44 +567,140 @@ func (b *batcher) poll(parent context.Context) {
 	}
 }
 
-func (b *batcher) Append(msg *lpb.LogRecord) {
-	if msg == nil {
+func (b *batcher) Append(msg record) {
+	if msg.LogRecord == nil {
 		return
 	}
-	b.appender.Load().(func(*lpb.LogRecord))(msg)
+	b.appender.Load().(func(record))(msg)
 }
 
-func (b *batcher) append(msg *lpb.LogRecord) {
+func (b *batcher) append(msg record) {
 	b.activeMu.Lock()
 	defer b.activeMu.Unlock()
-	if complete := b.active.Append(msg); complete {
-		b.export(b.active.Flush())
+	complete := b.active.Append(msg)
+	switch {
+	case b.severe(msg):
+		b.enqueueSevere()
+	case complete:
+		b.enqueue()
 	}
 }
 
-func (b *batcher) Shutdown() { b.shutdownOnce.Do(b.shutdown) }
+// severe returns if msg is severe enough to be exported immediately.
+func (b *batcher) severe(msg record) bool {
+	return b.flushSeverity != lpb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED &&
+		msg.GetSeverityNumber() >= b.flushSeverity
+}
 
-func (b *batcher) shutdown() {
-	b.appender.Store(func(*lpb.LogRecord) {})
+// Flush exports all queued messages. It returns once all queued messages,
+// including those already sent to be exported, have been exported or ctx is
+// done.
+func (b *batcher) Flush(ctx context.Context) error {
+	b.activeMu.Lock()
+	b.enqueueWait(ctx)
+	b.activeMu.Unlock()
+
+	return b.pending.Wait(ctx)
+}
+
+// Shutdown flushes all queued messages and stops the batcher. Messages
+// appended after Shutdown is called are dropped. Only the first call to
+// Shutdown performs the shutdown, subsequent calls return nil.
+//
+// If ctx is done before all queued messages are exported, any in-flight
+// export is canceled and the remaining messages are dropped. Once the
+// in-flight exports return, the batcher is still stopped and the context
+// error is returned along with any error from stopping it.
+func (b *batcher) Shutdown(ctx context.Context) error {
+	var err error
+	b.shutdownOnce.Do(func() { err = b.shutdown(ctx) })
+	return err
+}
+
+func (b *batcher) shutdown(ctx context.Context) error {
+	b.appender.Store(func(record) {})
+
+	// Close the poller so it no longer sends to the queue.
+	b.pollCancel()
+	b.pollWG.Wait()
 
 	// Acquire the lock after switching the appender to both ensure no active
 	// calls to Append are in progress and guard the active batch.
 	b.activeMu.Lock()
-	b.export(b.active.Flush())
+	b.enqueueWait(ctx)
+	b.closed = true
+	close(b.queue)
+	if b.disk != nil {
+		if err := b.disk.Close(); err != nil {
+			b.err(fmt.Errorf("otlpr: failed to close disk queue: %w", err))
+		}
+	}
 	b.activeMu.Unlock()
 
-	// Close poller.
-	b.cancel()
-	done := make(chan struct{}, 1)
+	done := make(chan struct{})
 	go func() {
-		b.wg.Wait()
-		done <- struct{}{}
+		b.workerWG.Wait()
 		close(done)
 	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	// Abort any in-flight and remaining exports. They cannot be retried, so
+	// stop is always called to release the exporter's resources. Wait for
+	// the workers to return first so nothing is exported after it.
+	b.cancel()
 	<-done
+	return errors.Join(err, b.stop(ctx))
+}
+
+// pending tracks the number of batches waiting to be exported.
+type pending struct {
+	mu   sync.Mutex
+	n    int
+	idle chan struct{} // Closed when n is zero.
+}
+
+func newPending() *pending {
+	idle := make(chan struct{})
+	close(idle)
+	return &pending{idle: idle}
+}
+
+// Add adds delta, which may be negative, to the number of pending batches.
+func (p *pending) Add(delta int) {
+	if delta == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.n == 0 {
+		p.idle = make(chan struct{})
+	}
+	if p.n += delta; p.n == 0 {
+		close(p.idle)
+	}
+}
+
+// Wait blocks until there are no pending batches or ctx is done.
+func (p *pending) Wait(ctx context.Context) error {
+	p.mu.Lock()
+	idle := p.idle
+	p.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-type batch []*lpb.LogRecord
+type batch []record
 
 func newBatch(n uint64) *batch {
 	b := make(batch, 0, int(n))
@@ -192,12 +718,12 @@ func (b *batch) Timestamp() time.Time {
 	return time.Unix(0, int64((*b)[0].GetTimeUnixNano()))
 }
 
-func (b *batch) Append(msg *lpb.LogRecord) bool {
+func (b *batch) Append(msg record) bool {
 	*b = append(*b, msg)
 	return b.Len() == cap(*b)
 }
 
-func (b *batch) Flush() []*lpb.LogRecord {
+func (b *batch) Flush() []record {
 	cp := make(batch, b.Len())
 	copy(cp, *b)
 	*b = (*b)[:0]
@@ -15,23 +15,38 @@
 package otlpr
 
 import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cpb "go.opentelemetry.io/proto/otlp/common/v1"
 	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
 )
 
-func expFn(chSize int) (<-chan []*lpb.LogRecord, exportFunc) {
-	c := make(chan []*lpb.LogRecord, chSize)
-	f := func(in []*lpb.LogRecord) { c <- in }
+// newRecord returns lr as a record without a resource or scope.
+func newRecord(lr *lpb.LogRecord) record {
+	return record{LogRecord: lr, origin: new(origin)}
+}
+
+func expFn(chSize int) (<-chan []record, exportFunc) {
+	c := make(chan []record, chSize)
+	f := func(_ context.Context, in []record) error {
+		c <- in
+		return nil
+	}
 	return c, f
 }
 
 func TestChunk(t *testing.T) {
 	c, f := expFn(3)
 	f = chunk(10, f)
-	f(make([]*lpb.LogRecord, 25))
+	f(context.Background(), make([]record, 25))
 
 	expectedLen := []int{10, 10, 5}
 	for i, n := range expectedLen {
@@ -46,7 +61,38 @@ func TestChunk(t *testing.T) {
 	}
 }
 
-func assertNoExport(t *testing.T, c <-chan []*lpb.LogRecord) {
+func TestChunkBytes(t *testing.T) {
+	rec := func(body string) record {
+		return newRecord(&lpb.LogRecord{Body: &cpb.AnyValue{
+			Value: &cpb.AnyValue_StringValue{StringValue: body},
+		}})
+	}
+	small, large := rec("small"), rec(strings.Repeat("large", 10))
+	n := recordSize(small)
+	require.Less(t, 2*n, recordSize(large))
+
+	c, f := expFn(3)
+	var dropped []record
+	f = chunkBytes(2*n, f, func(r record, _ int) {
+		dropped = append(dropped, r)
+	})
+	f(context.Background(), []record{small, small, small, large, small})
+
+	expectedLen := []int{2, 1, 1}
+	for i, n := range expectedLen {
+		got := <-c
+		assert.Lenf(t, got, n, "chunk %d", i)
+	}
+	assert.Equal(t, []record{large}, dropped)
+
+	select {
+	case v := <-c:
+		assert.Failf(t, "extra chunk", "length: %d", len(v))
+	default:
+	}
+}
+
+func assertNoExport(t *testing.T, c <-chan []record) {
 	t.Helper()
 	select {
 	case got := <-c:
@@ -55,20 +101,20 @@ func assertNoExport(t *testing.T, c <-chan []*lpb.LogRecord) {
 	}
 }
 
-func assertExport(t *testing.T, c <-chan []*lpb.LogRecord, n int) {
+func assertExport(t *testing.T, c <-chan []record, n int) {
 	t.Helper()
 	select {
 	case got := <-c:
 		assert.Len(t, got, n)
-	default:
+	case <-time.After(3 * time.Second):
 		assert.Fail(t, "missing export")
 	}
 }
 
 func TestMessages(t *testing.T) {
 	c, f := expFn(1)
-	b := Batcher{Messages: 3}.start(f)
-	msg := &lpb.LogRecord{}
+	b := Batcher{Messages: 3}.start(f, nil, nil)
+	msg := newRecord(&lpb.LogRecord{})
 
 	b.Append(msg)
 	assertNoExport(t, c)
@@ -80,10 +126,28 @@ func TestMessages(t *testing.T) {
 	assertExport(t, c, 3)
 }
 
+func TestFlushOnSeverity(t *testing.T) {
+	c, f := expFn(1)
+	b := Batcher{
+		Messages:        2048,
+		FlushOnSeverity: lpb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+	}.start(f, nil, nil)
+
+	b.Append(newRecord(&lpb.LogRecord{SeverityNumber: lpb.SeverityNumber_SEVERITY_NUMBER_INFO}))
+	b.Append(newRecord(&lpb.LogRecord{SeverityNumber: lpb.SeverityNumber_SEVERITY_NUMBER_WARN}))
+	assertNoExport(t, c)
+
+	b.Append(newRecord(&lpb.LogRecord{SeverityNumber: lpb.SeverityNumber_SEVERITY_NUMBER_ERROR}))
+	assertExport(t, c, 3)
+
+	b.Append(newRecord(&lpb.LogRecord{SeverityNumber: lpb.SeverityNumber_SEVERITY_NUMBER_FATAL}))
+	assertExport(t, c, 1)
+}
+
 func TestTimeout(t *testing.T) {
 	c, f := expFn(1)
-	b := Batcher{Messages: 2048, Timeout: time.Nanosecond}.start(f)
-	msg := &lpb.LogRecord{}
+	b := Batcher{Messages: 2048, Timeout: time.Nanosecond}.start(f, nil, nil)
+	msg := newRecord(&lpb.LogRecord{})
 
 	b.Append(msg)
 	select {
@@ -93,3 +157,300 @@ func TestTimeout(t *testing.T) {
 		assert.Fail(t, "missing export")
 	}
 }
+
+func TestShutdownAbortsExport(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	f := func(ctx context.Context, _ []record) error {
+		close(started)
+		<-ctx.Done()
+		close(aborted)
+		return ctx.Err()
+	}
+	stopErr := errors.New("stop")
+	stopped := false
+	stop := func(context.Context) error {
+		stopped = true
+		return stopErr
+	}
+	b := Batcher{Messages: 1}.start(f, stop, nil)
+
+	b.Append(newRecord(&lpb.LogRecord{}))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := b.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, stopErr)
+	assert.True(t, stopped, "batcher not stopped")
+
+	select {
+	case <-aborted:
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "stalled export not canceled")
+	}
+}
+
+func TestShutdownDropsQueued(t *testing.T) {
+	var exports, afterStop atomic.Int64
+	var stopped atomic.Bool
+	started := make(chan struct{}, 1)
+	f := func(ctx context.Context, _ []record) error {
+		exports.Add(1)
+		if stopped.Load() {
+			afterStop.Add(1)
+		}
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	stop := func(context.Context) error {
+		stopped.Store(true)
+		return nil
+	}
+	b := Batcher{Messages: 1, MaxQueueSize: 5}.start(f, stop, nil)
+
+	for range 5 {
+		b.Append(newRecord(&lpb.LogRecord{}))
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.Shutdown(ctx), context.DeadlineExceeded)
+	b.workerWG.Wait()
+	assert.True(t, stopped.Load(), "batcher not stopped")
+	assert.Equal(t, int64(1), exports.Load(), "queued batches exported")
+	assert.Equal(t, int64(0), afterStop.Load(), "exported after stop")
+	assert.Equal(t, uint64(4), b.Dropped())
+}
+
+func TestAppendDoesNotWaitOnExport(t *testing.T) {
+	release := make(chan struct{})
+	f := func(context.Context, []record) error {
+		<-release
+		return nil
+	}
+	b := Batcher{Messages: 1}.start(f, nil, nil)
+	t.Cleanup(func() {
+		close(release)
+		_ = b.Shutdown(context.Background())
+	})
+
+	done := make(chan struct{})
+	go func() {
+		// The first append is being exported, the second is queued.
+		b.Append(newRecord(&lpb.LogRecord{}))
+		b.Append(newRecord(&lpb.LogRecord{}))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "append blocked by export")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	c, f := expFn(1)
+	b := Batcher{Messages: 3}.start(f, nil, nil)
+
+	b.Append(newRecord(&lpb.LogRecord{}))
+	assertNoExport(t, c)
+
+	assert.NoError(t, b.Flush(context.Background()))
+	assertExport(t, c, 1)
+
+	// Nothing is exported if the queue is empty.
+	assert.NoError(t, b.Flush(context.Background()))
+	assertNoExport(t, c)
+}
+
+func TestShutdown(t *testing.T) {
+	c, f := expFn(1)
+	var stopped bool
+	b := Batcher{Messages: 3}.start(f, func(context.Context) error {
+		stopped = true
+		return nil
+	}, nil)
+
+	b.Append(newRecord(&lpb.LogRecord{}))
+	assert.NoError(t, b.Shutdown(context.Background()))
+	assertExport(t, c, 1)
+	assert.True(t, stopped)
+
+	b.Append(newRecord(&lpb.LogRecord{}))
+	assert.NoError(t, b.Shutdown(context.Background()))
+	assertNoExport(t, c)
+}
+
+// fullQueue returns a batcher exporting with a single message per batch to
+// a blocked exporter and a queue of size 1 that is full. The returned channel
+// receives the TimeUnixNano of each exported record once release is called.
+func fullQueue(t *testing.T, conf Batcher, errFn func(error)) (b *batcher, exported <-chan uint64, release func()) {
+	t.Helper()
+
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	c := make(chan uint64, 3)
+	f := func(_ context.Context, lr []record) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-unblock
+		for _, r := range lr {
+			c <- r.TimeUnixNano
+		}
+		return nil
+	}
+
+	conf.Messages, conf.MaxQueueSize = 1, 1
+	b = conf.start(f, nil, errFn)
+
+	b.Append(newRecord(&lpb.LogRecord{TimeUnixNano: 1}))
+	<-started // Record 1 is being exported.
+	b.Append(newRecord(&lpb.LogRecord{TimeUnixNano: 2}))
+
+	var once sync.Once
+	release = func() { once.Do(func() { close(unblock) }) }
+	t.Cleanup(func() {
+		release()
+		_ = b.Shutdown(context.Background())
+	})
+	return b, c, release
+}
+
+func collect(t *testing.T, b *batcher, c <-chan uint64) []uint64 {
+	t.Helper()
+	require.NoError(t, b.Flush(context.Background()))
+	var got []uint64
+	for {
+		select {
+		case ts := <-c:
+			got = append(got, ts)
+		default:
+			return got
+		}
+	}
+}
+
+func TestQueuePolicyDropNewest(t *testing.T) {
+	b, c, release := fullQueue(t, Batcher{QueuePolicy: DropNewest}, nil)
+
+	b.Append(newRecord(&lpb.LogRecord{TimeUnixNano: 3}))
+	assert.Equal(t, uint64(1), b.Dropped())
+
+	release()
+	assert.Equal(t, []uint64{1, 2}, collect(t, b, c))
+}
+
+func TestQueuePolicyDropOldest(t *testing.T) {
+	b, c, release := fullQueue(t, Batcher{QueuePolicy: DropOldest}, nil)
+
+	b.Append(newRecord(&lpb.LogRecord{TimeUnixNano: 3}))
+	assert.Equal(t, uint64(1), b.Dropped())
+
+	release()
+	assert.Equal(t, []uint64{1, 3}, collect(t, b, c))
+}
+
+func TestQueuePolicyBlockTimeout(t *testing.T) {
+	b, c, release := fullQueue(t, Batcher{
+		QueuePolicy:  Block,
+		BlockTimeout: 10 * time.Millisecond,
+	}, nil)
+
+	b.Append(newRecord(&lpb.LogRecord{TimeUnixNano: 3}))
+	assert.Equal(t, uint64(1), b.Dropped())
+
+	release()
+	assert.Equal(t, []uint64{1, 2}, collect(t, b, c))
+}
+
+func TestQueuePolicyBlock(t *testing.T) {
+	b, c, release := fullQueue(t, Batcher{QueuePolicy: Block}, nil)
+
+	done := make(chan struct{})
+	go func() {
+		b.Append(newRecord(&lpb.LogRecord{TimeUnixNano: 3}))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		assert.Fail(t, "append did not block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release()
+	<-done
+	assert.Equal(t, uint64(0), b.Dropped())
+	assert.Equal(t, []uint64{1, 2, 3}, collect(t, b, c))
+}
+
+func TestFlushOnSeverityFullQueue(t *testing.T) {
+	b, c, release := fullQueue(t, Batcher{
+		QueuePolicy:     DropNewest,
+		FlushOnSeverity: lpb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+	}, nil)
+
+	// Room is made in the queue before the severe batch is dropped.
+	time.AfterFunc(10*time.Millisecond, release)
+	b.Append(newRecord(&lpb.LogRecord{
+		TimeUnixNano:   3,
+		SeverityNumber: lpb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+	}))
+	assert.Equal(t, uint64(0), b.Dropped())
+	assert.Equal(t, []uint64{1, 2, 3}, collect(t, b, c))
+}
+
+func TestFlushOnSeverityFullQueueDropped(t *testing.T) {
+	errs := make(chan error, 1)
+	b, c, release := fullQueue(t, Batcher{
+		QueuePolicy:     DropNewest,
+		FlushOnSeverity: lpb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+	}, func(err error) { errs <- err })
+
+	b.Append(newRecord(&lpb.LogRecord{
+		TimeUnixNano:   3,
+		SeverityNumber: lpb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+	}))
+	assert.Equal(t, uint64(1), b.Dropped())
+	assert.ErrorContains(t, <-errs, "dropped 1 log records")
+
+	release()
+	assert.Equal(t, []uint64{1, 2}, collect(t, b, c))
+}
+
+func TestWorkers(t *testing.T) {
+	const workers = 3
+
+	started := make(chan struct{}, workers)
+	release := make(chan struct{})
+	f := func(context.Context, []record) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+	b := Batcher{Messages: 1, Workers: workers}.start(f, nil, nil)
+
+	for i := 0; i < workers; i++ {
+		b.Append(newRecord(&lpb.LogRecord{}))
+	}
+	for i := 0; i < workers; i++ {
+		select {
+		case <-started:
+		case <-time.After(3 * time.Second):
+			require.Fail(t, "exports not concurrent")
+		}
+	}
+
+	close(release)
+	assert.NoError(t, b.Shutdown(context.Background()))
+}
@@ -0,0 +1,516 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Defaults for DiskQueue.
+const (
+	defaultMaxSegmentBytes = 1 << 20  // 1 MiB
+	defaultMaxDiskBytes    = 64 << 20 // 64 MiB
+)
+
+// segmentExt is the file extension of disk queue segment files.
+const segmentExt = ".wal"
+
+// ackExt is the file extension of the files recording the acknowledged
+// position of each segment.
+const ackExt = ".ack"
+
+// DiskQueue configures a disk-backed queue of batches waiting to be exported.
+//
+// Batches are appended to segment files in Dir before they are exported. A
+// segment is deleted once all the batches it contains have been successfully
+// exported. Any batches remaining in Dir when a logger is started, (e.g.
+// because the process exited before they could be exported) are replayed and
+// exported by the new logger.
+//
+// Batches that fail to export due to a transient error (e.g. the receiving
+// endpoint is unavailable) are retried until they are exported. Use a
+// context with a deadline when calling Shutdown, batches that have not been
+// exported by then remain on disk.
+//
+// The position up to which all batches of a segment have been exported is
+// recorded in a file next to it, and only the batches after it are replayed.
+// Delivery is at-least-once: batches that were being exported when the
+// process exited, or that were exported while an earlier batch of the same
+// segment was not, are exported again when replayed.
+//
+// Segments are written without calling fsync, batches are not guaranteed to
+// survive an operating system crash.
+type DiskQueue struct {
+	// Dir is the directory segment files are stored in. It is created if it
+	// does not exist. The directory must not be shared with another logger.
+	//
+	// If Dir is empty, the disk-backed queue is not used.
+	Dir string
+	// MaxSegmentBytes is the size a segment file can grow to before a new
+	// segment is started.
+	//
+	// If MaxSegmentBytes is less than or equal to zero, the default value of
+	// 1 MiB is used.
+	MaxSegmentBytes int64
+	// MaxBytes is the maximum total size of all segment files. Once exceeded,
+	// the oldest segments are deleted and the log records they contain that
+	// have not been exported are dropped.
+	//
+	// If MaxBytes is less than or equal to zero, the default value of 64 MiB
+	// is used.
+	MaxBytes int64
+}
+
+// segment is a single file of a diskQueue.
+type segment struct {
+	id   uint64
+	path string
+	size int64
+
+	// batches and records are the number written to the segment.
+	batches, records int
+	// read and readRecords are the number of batches and records read from
+	// the segment. off is the file offset of the next batch to read.
+	read, readRecords int
+	off               int64
+	// acked is the number of read batches that were successfully exported.
+	acked int
+	// ackOff is the file offset all batches before have been acknowledged.
+	// acks holds the start and end offsets of acknowledged batches after it.
+	ackOff int64
+	acks   map[int64]int64
+}
+
+// batchID identifies a batch read from a diskQueue.
+type batchID struct {
+	segment uint64
+	// start and end are the file offsets of the batch in the segment.
+	start, end int64
+}
+
+func (s *segment) done() bool { return s.acked == s.batches }
+
+// diskQueue is a FIFO queue of batches persisted in segment files.
+type diskQueue struct {
+	conf   DiskQueue
+	onDrop func(batches, records int)
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	segments []*segment // Oldest first. The last is the write segment.
+	w        *os.File
+	closed   bool
+}
+
+// openDiskQueue opens the disk queue defined by conf, replaying any segments
+// already stored in conf.Dir. The onDrop function is called with the number
+// of unread batches and records whenever a segment is dropped due to the
+// conf.MaxBytes limit. The number of replayed batches is returned.
+func openDiskQueue(conf DiskQueue, onDrop func(batches, records int)) (*diskQueue, int, error) {
+	if conf.MaxSegmentBytes <= 0 {
+		conf.MaxSegmentBytes = defaultMaxSegmentBytes
+	}
+	if conf.MaxBytes <= 0 {
+		conf.MaxBytes = defaultMaxDiskBytes
+	}
+	if err := os.MkdirAll(conf.Dir, 0o750); err != nil {
+		return nil, 0, err
+	}
+
+	q := &diskQueue{conf: conf, onDrop: onDrop}
+	q.cond = sync.NewCond(&q.mu)
+
+	replayed, err := q.replay()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next uint64
+	if n := len(q.segments); n > 0 {
+		next = q.segments[n-1].id + 1
+	}
+	if err := q.newSegment(next); err != nil {
+		return nil, 0, err
+	}
+	return q, replayed, nil
+}
+
+// replay loads the existing segments in the queue directory.
+func (q *diskQueue) replay() (int, error) {
+	entries, err := os.ReadDir(q.conf.Dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var replayed int
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s := &segment{id: id, path: filepath.Join(q.conf.Dir, name)}
+		if err := scanSegment(s); err != nil {
+			return 0, err
+		}
+		if s.done() {
+			q.remove(s)
+			continue
+		}
+		replayed += s.batches - s.read
+		q.segments = append(q.segments, s)
+	}
+
+	// Remove the acknowledged positions of segments that no longer exist.
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ackExt) {
+			continue
+		}
+		seg := filepath.Join(q.conf.Dir, strings.TrimSuffix(name, ackExt)+segmentExt)
+		if _, err := os.Stat(seg); errors.Is(err, os.ErrNotExist) {
+			_ = os.Remove(filepath.Join(q.conf.Dir, name))
+		}
+	}
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i].id < q.segments[j].id
+	})
+	return replayed, nil
+}
+
+// scanSegment counts the valid batches and records stored in s. Reading stops
+// at the first incomplete or corrupt batch, which can be left behind if the
+// process exited while writing it. Batches before the acknowledged position
+// of s are counted as read and acknowledged.
+func scanSegment(s *segment) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ackOff := readAckOff(ackPath(s.path))
+	r := bufio.NewReader(f)
+	for {
+		n, msg, err := readBatch(r)
+		if err != nil {
+			return nil
+		}
+		recs := len(records(msg.GetResourceLogs()))
+		s.batches++
+		s.records += recs
+		s.size += int64(n)
+		if s.size <= ackOff {
+			s.read++
+			s.readRecords += recs
+			s.acked++
+			s.off, s.ackOff = s.size, s.size
+		}
+	}
+}
+
+// ackPath returns the path of the file recording the acknowledged position
+// of the segment at path.
+func ackPath(path string) string {
+	return strings.TrimSuffix(path, segmentExt) + ackExt
+}
+
+// readAckOff returns the acknowledged position stored at path. Zero is
+// returned if it is missing or invalid, replaying the whole segment.
+func readAckOff(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) != 8 {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(data))
+}
+
+func (q *diskQueue) newSegment(id uint64) error {
+	path := filepath.Join(q.conf.Dir, fmt.Sprintf("%020d%s", id, segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	q.w = f
+	q.segments = append(q.segments, &segment{id: id, path: path})
+	return nil
+}
+
+// Push appends batch to the queue.
+func (q *diskQueue) Push(batch []record) error {
+	data, err := proto.Marshal(&lpb.LogsData{ResourceLogs: resourceLogs(batch)})
+	if err != nil {
+		return err
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(data)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(data))
+	buf = append(buf, data...)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errors.New("disk queue closed")
+	}
+
+	w := q.segments[len(q.segments)-1]
+	if w.size > 0 && w.size+int64(len(buf)) > q.conf.MaxSegmentBytes {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+		w = q.segments[len(q.segments)-1]
+	}
+
+	if _, err := q.w.Write(buf); err != nil {
+		// Remove any partial write so following batches remain readable.
+		_ = q.w.Truncate(w.size)
+		_, _ = q.w.Seek(w.size, io.SeekStart)
+		return err
+	}
+	w.size += int64(len(buf))
+	w.batches++
+	w.records += len(batch)
+
+	q.enforceMaxBytes()
+	q.cond.Signal()
+	return nil
+}
+
+// rotate starts a new write segment. The mu needs to be held.
+func (q *diskQueue) rotate() error {
+	if err := q.w.Close(); err != nil {
+		return err
+	}
+	prev := q.segments[len(q.segments)-1]
+	if err := q.newSegment(prev.id + 1); err != nil {
+		return err
+	}
+	if prev.done() {
+		q.remove(prev)
+	}
+	return nil
+}
+
+// enforceMaxBytes drops the oldest segments until the total size of all
+// segments is within the MaxBytes limit. The write segment is never dropped.
+// The mu needs to be held.
+func (q *diskQueue) enforceMaxBytes() {
+	var total int64
+	for _, s := range q.segments {
+		total += s.size
+	}
+
+	for total > q.conf.MaxBytes && len(q.segments) > 1 {
+		s := q.segments[0]
+		total -= s.size
+		q.remove(s)
+		if n := s.batches - s.read; n > 0 {
+			q.onDrop(n, s.records-s.readRecords)
+		}
+	}
+}
+
+// remove deletes s. The mu needs to be held.
+func (q *diskQueue) remove(s *segment) {
+	for i, seg := range q.segments {
+		if seg == s {
+			q.segments = append(q.segments[:i], q.segments[i+1:]...)
+			break
+		}
+	}
+	_ = os.Remove(s.path)
+	_ = os.Remove(ackPath(s.path))
+}
+
+// Next returns the oldest batch that has not been read and its ID. The ID
+// needs to be passed to Ack once the batch has been successfully exported.
+// Next blocks until a batch is available. If the queue is closed and has no
+// more batches, false is returned.
+func (q *diskQueue) Next() ([]record, batchID, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for _, s := range q.segments {
+			if s.read == s.batches {
+				continue
+			}
+
+			start := s.off
+			msg, err := q.readNext(s)
+			if err != nil {
+				// The rest of the segment is unreadable. Skip it.
+				n := s.batches - s.read
+				q.onDrop(n, s.records-s.readRecords)
+				s.batches, s.records = s.read, s.readRecords
+				if s.done() && s != q.segments[len(q.segments)-1] {
+					q.remove(s)
+				}
+				break
+			}
+			id := batchID{segment: s.id, start: start, end: s.off}
+			return records(msg.GetResourceLogs()), id, true
+		}
+
+		if q.unread() {
+			continue
+		}
+		if q.closed {
+			return nil, batchID{}, false
+		}
+		q.cond.Wait()
+	}
+}
+
+// unread returns if any segment has unread batches. The mu needs to be held.
+func (q *diskQueue) unread() bool {
+	for _, s := range q.segments {
+		if s.read < s.batches {
+			return true
+		}
+	}
+	return false
+}
+
+// readNext reads the next batch from s. The mu needs to be held.
+func (q *diskQueue) readNext(s *segment) (*lpb.LogsData, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(s.off, io.SeekStart); err != nil {
+		return nil, err
+	}
+	n, msg, err := readBatch(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	s.off += int64(n)
+	s.read++
+	s.readRecords += len(records(msg.GetResourceLogs()))
+	return msg, nil
+}
+
+// Ack marks the batch with id as exported. Segments are deleted once all of
+// their batches are acknowledged. Otherwise, the position up to which all
+// batches of the segment are acknowledged is persisted so they are not
+// replayed.
+func (q *diskQueue) Ack(id batchID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, s := range q.segments {
+		if s.id != id.segment {
+			continue
+		}
+		s.acked++
+		// The write segment is deleted when it is rotated or the queue is
+		// closed.
+		if s.done() && (i < len(q.segments)-1 || q.closed) {
+			q.remove(s)
+			return
+		}
+
+		if id.start != s.ackOff {
+			// An earlier batch is still being exported.
+			if s.acks == nil {
+				s.acks = make(map[int64]int64)
+			}
+			s.acks[id.start] = id.end
+			return
+		}
+		s.ackOff = id.end
+		for end, ok := s.acks[s.ackOff]; ok; end, ok = s.acks[s.ackOff] {
+			delete(s.acks, s.ackOff)
+			s.ackOff = end
+		}
+		// If this fails the acknowledged batches are replayed, which is
+		// allowed by the at-least-once delivery of the queue.
+		buf := binary.LittleEndian.AppendUint64(nil, uint64(s.ackOff))
+		_ = os.WriteFile(ackPath(s.path), buf, 0o640)
+		return
+	}
+}
+
+// Close closes the queue. Batches are no longer accepted, and Next returns
+// false once all batches have been read. Unacknowledged batches remain on
+// disk to be replayed by the next queue opened in the same directory.
+func (q *diskQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.cond.Broadcast()
+
+	err := q.w.Close()
+	if s := q.segments[len(q.segments)-1]; s.batches == 0 || s.done() {
+		q.remove(s)
+	}
+	return err
+}
+
+// readBatch reads a single batch from r. It returns the number of bytes
+// read.
+func readBatch(r *bufio.Reader) (int, *lpb.LogsData, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return 0, nil, err
+	}
+	// Do not trust size to allocate the buffer, it may be corrupt.
+	data, err := io.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return 0, nil, err
+	}
+	if uint64(len(data)) != size {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(sum[:]) {
+		return 0, nil, errors.New("corrupt batch: checksum mismatch")
+	}
+
+	msg := new(lpb.LogsData)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return 0, nil, err
+	}
+	n := len(binary.AppendUvarint(nil, size)) + len(sum) + len(data)
+	return n, msg, nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	m, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	return m
+}
+
+func newRecords(ts ...uint64) []record {
+	o := new(origin)
+	out := make([]record, len(ts))
+	for i, t := range ts {
+		out[i] = record{LogRecord: &lpb.LogRecord{TimeUnixNano: t}, origin: o}
+	}
+	return out
+}
+
+func timestamps(lr []record) []uint64 {
+	out := make([]uint64, len(lr))
+	for i, r := range lr {
+		out[i] = r.TimeUnixNano
+	}
+	return out
+}
+
+func noDrop(t *testing.T) func(int, int) {
+	return func(b, r int) { assert.Failf(t, "unexpected drop", "%d batches, %d records", b, r) }
+}
+
+func TestDiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, n, err := openDiskQueue(DiskQueue{Dir: dir}, noDrop(t))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	require.NoError(t, q.Push(newRecords(1, 2)))
+	require.NoError(t, q.Push(newRecords(3)))
+
+	batch, id, ok := q.Next()
+	require.True(t, ok)
+	assert.Equal(t, []uint64{1, 2}, timestamps(batch))
+	q.Ack(id)
+
+	batch, id, ok = q.Next()
+	require.True(t, ok)
+	assert.Equal(t, []uint64{3}, timestamps(batch))
+	q.Ack(id)
+
+	require.NoError(t, q.Close())
+	_, _, ok = q.Next()
+	assert.False(t, ok)
+	assert.Empty(t, segments(t, dir), "exported segments not deleted")
+}
+
+func TestDiskQueueReplay(t *testing.T) {
+	dir := t.TempDir()
+	q, _, err := openDiskQueue(DiskQueue{Dir: dir}, noDrop(t))
+	require.NoError(t, err)
+
+	require.NoError(t, q.Push(newRecords(1)))
+	require.NoError(t, q.Push(newRecords(2)))
+
+	// Export, but do not acknowledge, the first batch.
+	_, _, ok := q.Next()
+	require.True(t, ok)
+	require.NoError(t, q.Close())
+
+	q, n, err := openDiskQueue(DiskQueue{Dir: dir}, noDrop(t))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	batch, _, ok := q.Next()
+	require.True(t, ok)
+	assert.Equal(t, []uint64{1}, timestamps(batch))
+	batch, _, ok = q.Next()
+	require.True(t, ok)
+	assert.Equal(t, []uint64{2}, timestamps(batch))
+	require.NoError(t, q.Close())
+}
+
+func TestDiskQueueReplayAcked(t *testing.T) {
+	dir := t.TempDir()
+	q, _, err := openDiskQueue(DiskQueue{Dir: dir}, noDrop(t))
+	require.NoError(t, err)
+
+	for i := uint64(1); i <= 4; i++ {
+		require.NoError(t, q.Push(newRecords(i)))
+	}
+	ids := make([]batchID, 4)
+	for i := range ids {
+		var ok bool
+		_, ids[i], ok = q.Next()
+		require.True(t, ok)
+	}
+	// Acknowledged out of order, the first three batches are exported.
+	q.Ack(ids[1])
+	q.Ack(ids[2])
+	q.Ack(ids[0])
+	require.NoError(t, q.Close())
+
+	q, n, err := openDiskQueue(DiskQueue{Dir: dir}, noDrop(t))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "acknowledged batches replayed")
+
+	batch, id, ok := q.Next()
+	require.True(t, ok)
+	assert.Equal(t, []uint64{4}, timestamps(batch))
+	q.Ack(id)
+	require.NoError(t, q.Close())
+
+	m, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Empty(t, m, "exported segments not deleted")
+}
+
+func TestDiskQueueReplayCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+	q, _, err := openDiskQueue(DiskQueue{Dir: dir}, noDrop(t))
+	require.NoError(t, err)
+	require.NoError(t, q.Push(newRecords(1)))
+	require.NoError(t, q.Close())
+
+	// Simulate a partially written batch.
+	seg := segments(t, dir)
+	require.Len(t, seg, 1)
+	f, err := os.OpenFile(seg[0], os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{10, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	q, n, err := openDiskQueue(DiskQueue{Dir: dir}, noDrop(t))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	batch, _, ok := q.Next()
+	require.True(t, ok)
+	assert.Equal(t, []uint64{1}, timestamps(batch))
+	require.NoError(t, q.Close())
+}
+
+func TestDiskQueueRotation(t *testing.T) {
+	dir := t.TempDir()
+	q, _, err := openDiskQueue(DiskQueue{Dir: dir, MaxSegmentBytes: 1}, noDrop(t))
+	require.NoError(t, err)
+
+	// Each batch is larger than MaxSegmentBytes, they each get a segment.
+	require.NoError(t, q.Push(newRecords(1)))
+	require.NoError(t, q.Push(newRecords(2)))
+	assert.Len(t, segments(t, dir), 2)
+
+	_, id, ok := q.Next()
+	require.True(t, ok)
+	q.Ack(id)
+	assert.Len(t, segments(t, dir), 1, "exported segment not deleted")
+	require.NoError(t, q.Close())
+}
+
+func TestDiskQueueMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	var droppedBatches, droppedRecords int
+	q, _, err := openDiskQueue(DiskQueue{
+		Dir:             dir,
+		MaxSegmentBytes: 1,
+		MaxBytes:        1,
+	}, func(b, r int) {
+		droppedBatches += b
+		droppedRecords += r
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, q.Push(newRecords(1, 2)))
+	require.NoError(t, q.Push(newRecords(3)))
+	assert.Equal(t, 1, droppedBatches)
+	assert.Equal(t, 2, droppedRecords)
+
+	batch, _, ok := q.Next()
+	require.True(t, ok)
+	assert.Equal(t, []uint64{3}, timestamps(batch))
+	require.NoError(t, q.Close())
+}
+
+func TestBatcherDiskQueueReplay(t *testing.T) {
+	dir := t.TempDir()
+	unavailable := func(context.Context, []record) error {
+		return status.Error(codes.Unavailable, "")
+	}
+	conf := Batcher{Messages: 1, DiskQueue: DiskQueue{Dir: dir}}
+	b := conf.start(unavailable, nil, nil)
+	b.Append(newRecord(&lpb.LogRecord{TimeUnixNano: 1}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Len(t, segments(t, dir), 1, "unexported batch not persisted")
+
+	c, f := expFn(1)
+	b = conf.start(f, nil, nil)
+	got := <-c
+	assert.Equal(t, []uint64{1}, timestamps(got))
+
+	require.NoError(t, b.Shutdown(context.Background()))
+	assert.Empty(t, segments(t, dir), "exported segment not deleted")
+}
+
+func TestBatcherDiskQueueCleanup(t *testing.T) {
+	unavailable := func(context.Context, []record) error {
+		return status.Error(codes.Unavailable, "")
+	}
+	stopped := make(chan struct{})
+	stop := func(context.Context) error {
+		close(stopped)
+		return nil
+	}
+
+	dir := t.TempDir()
+	func() {
+		conf := Batcher{Messages: 1, DiskQueue: DiskQueue{Dir: dir}}
+		r := newBatcherRef(conf.start(unavailable, stop, nil), 10*time.Millisecond)
+		r.Append(newRecord(&lpb.LogRecord{TimeUnixNano: 1}))
+	}()
+
+	deadline := time.After(3 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case <-stopped:
+			assert.Len(t, segments(t, dir), 1, "unexported batch not left on disk")
+			return
+		case <-deadline:
+			require.Fail(t, "retrying batcher not shut down")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
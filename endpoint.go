@@ -0,0 +1,83 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Connection configures the gRPC connection dialed by NewFromEndpoint.
+type Connection struct {
+	// Insecure disables transport security. By default, TLS is used.
+	Insecure bool
+
+	// TLSConfig is the TLS configuration used to connect to the endpoint. If
+	// nil, the system defaults are used. It is ignored if Insecure is true.
+	TLSConfig *tls.Config
+
+	// Credentials, if not nil, are attached to every export (e.g. OAuth
+	// tokens).
+	Credentials credentials.PerRPCCredentials
+
+	// Keepalive, if not nil, configures keepalive pings on the connection.
+	Keepalive *keepalive.ClientParameters
+
+	// DialOptions are additional options used to dial the connection. They
+	// are applied last and override any conflicting settings.
+	DialOptions []grpc.DialOption
+}
+
+// NewFromEndpoint returns a new logr Logger that will export logs to the
+// OTLP/gRPC receiver at endpoint (e.g. "collector:4317"). The endpoint is a
+// gRPC target as accepted by grpc.NewClient.
+//
+// The connection is configured with opts.Connection and opts.Compression.
+// Unlike New, the logger owns the connection it creates. It is closed when
+// the logger is shut down (see Shutdown), even if the shutdown context is done
+// before all buffered log records are exported.
+//
+// An error is returned if the connection cannot be created.
+func NewFromEndpoint(endpoint string, opts Options) (logr.Logger, error) {
+	conn, err := grpc.NewClient(endpoint, opts.Connection.dialOptions()...)
+	if err != nil {
+		return logr.Discard(), fmt.Errorf("otlpr: failed to create connection: %w", err)
+	}
+
+	exp := newGRPCExporter(conn, opts.Compression)
+	exp.closer = conn
+	return NewWithExporter(exp, opts), nil
+}
+
+func (c Connection) dialOptions() []grpc.DialOption {
+	creds := insecure.NewCredentials()
+	if !c.Insecure {
+		creds = credentials.NewTLS(c.TLSConfig)
+	}
+	dOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if c.Credentials != nil {
+		dOpts = append(dOpts, grpc.WithPerRPCCredentials(c.Credentials))
+	}
+	if c.Keepalive != nil {
+		dOpts = append(dOpts, grpc.WithKeepaliveParams(*c.Keepalive))
+	}
+	return append(dOpts, c.DialOptions...)
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collpb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestNewFromEndpoint(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &logsServer{requests: make(chan *collpb.ExportLogsServiceRequest, 1)}
+	s := grpc.NewServer()
+	collpb.RegisterLogsServiceServer(s, srv)
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	l, err := NewFromEndpoint(lis.Addr().String(), Options{
+		Connection:  Connection{Insecure: true},
+		Compression: GzipCompression,
+	})
+	require.NoError(t, err)
+
+	l.Info("message")
+	require.NoError(t, Shutdown(context.Background(), l))
+	req := <-srv.requests
+	assert.Equal(t, "message", req.ResourceLogs[0].ScopeLogs[0].LogRecords[0].Body.GetStringValue())
+
+	exp := l.GetSink().(*logSink).exporter.(*grpcExporter)
+	assert.Equal(t, connectivity.Shutdown, exp.closer.(*grpc.ClientConn).GetState())
+}
+
+// stalledLogsServer is an OTLP receiver whose exports never complete.
+type stalledLogsServer struct {
+	collpb.UnimplementedLogsServiceServer
+
+	started chan struct{}
+}
+
+func (s *stalledLogsServer) Export(ctx context.Context, _ *collpb.ExportLogsServiceRequest) (*collpb.ExportLogsServiceResponse, error) {
+	s.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestNewFromEndpointShutdownTimeout(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &stalledLogsServer{started: make(chan struct{}, 1)}
+	s := grpc.NewServer()
+	collpb.RegisterLogsServiceServer(s, srv)
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	l, err := NewFromEndpoint(lis.Addr().String(), Options{
+		Batcher:       Batcher{Messages: 1},
+		Connection:    Connection{Insecure: true},
+		ExportTimeout: time.Hour,
+		ErrorHandler:  func(error) {},
+	})
+	require.NoError(t, err)
+
+	l.Info("message")
+	<-srv.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, Shutdown(ctx, l), context.DeadlineExceeded)
+
+	exp := l.GetSink().(*logSink).exporter.(*grpcExporter)
+	assert.Equal(t, connectivity.Shutdown, exp.closer.(*grpc.ClientConn).GetState())
+}
+
+func TestNewFromEndpointError(t *testing.T) {
+	l, err := NewFromEndpoint("dns://%", Options{})
+	assert.Error(t, err)
+	assert.Equal(t, logr.Discard(), l)
+}
@@ -0,0 +1,363 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+)
+
+// Supported values of OTEL_EXPORTER_OTLP_PROTOCOL.
+const (
+	protocolGRPC         = "grpc"
+	protocolHTTPProtobuf = "http/protobuf"
+	protocolHTTPJSON     = "http/json"
+)
+
+// Default endpoints used when none is configured in the environment.
+const (
+	defaultGRPCEndpoint = "http://localhost:4317"
+	defaultHTTPEndpoint = "http://localhost:4318"
+)
+
+// NewFromEnv returns a new logr Logger configured with opts and the standard
+// OpenTelemetry environment variables. Values set in the environment
+// override those in opts.
+//
+// The following OTLP exporter variables are supported. Each can also be set
+// for logs only by using the OTEL_EXPORTER_OTLP_LOGS_ prefix instead, which
+// takes precedence.
+//
+//   - OTEL_EXPORTER_OTLP_ENDPOINT: the endpoint URL. By default,
+//     "http://localhost:4317" for gRPC and "http://localhost:4318" for HTTP.
+//     The "/v1/logs" path is appended for HTTP unless the logs specific
+//     variable is used. An "http" scheme disables transport security for
+//     gRPC.
+//   - OTEL_EXPORTER_OTLP_INSECURE: disables transport security for gRPC if
+//     "true".
+//   - OTEL_EXPORTER_OTLP_PROTOCOL: "grpc" (default), "http/protobuf", or
+//     "http/json".
+//   - OTEL_EXPORTER_OTLP_HEADERS: comma separated key=value pairs sent with
+//     every export (see Options.Headers).
+//   - OTEL_EXPORTER_OTLP_TIMEOUT: Options.ExportTimeout in milliseconds.
+//   - OTEL_EXPORTER_OTLP_COMPRESSION: "gzip" or "none".
+//   - OTEL_EXPORTER_OTLP_CERTIFICATE: path to a PEM encoded certificate used
+//     to verify the endpoint.
+//
+// The following batch log record processor variables are also supported.
+//
+//   - OTEL_BLRP_SCHEDULE_DELAY: Batcher.Timeout in milliseconds.
+//   - OTEL_BLRP_EXPORT_TIMEOUT: Options.ExportTimeout in milliseconds. It is
+//     overridden by OTEL_EXPORTER_OTLP_TIMEOUT.
+//   - OTEL_BLRP_MAX_EXPORT_BATCH_SIZE: Batcher.Messages.
+//   - OTEL_BLRP_MAX_QUEUE_SIZE: the maximum number of messages waiting to be
+//     exported. Batcher.MaxQueueSize is set to the number of batches needed
+//     to hold that many messages. The batch size is limited to it.
+//
+// Invalid values are ignored and reported to the ErrorHandler. An error is
+// returned if the configured protocol is not supported or the exporter
+// cannot be created.
+func NewFromEnv(opts Options) (logr.Logger, error) {
+	errFn := opts.ErrorHandler
+	if errFn == nil {
+		errFn = otel.Handle
+	}
+	c := readEnv(os.Getenv)
+	for _, err := range c.errs {
+		errFn(fmt.Errorf("otlpr: invalid environment configuration: %w", err))
+	}
+	opts = c.apply(opts)
+
+	switch c.protocol {
+	case protocolGRPC:
+		return NewFromEndpoint(c.grpcTarget(), opts)
+	case protocolHTTPProtobuf, protocolHTTPJSON:
+		opts.HTTPEncoding = Protobuf
+		if c.protocol == protocolHTTPJSON {
+			opts.HTTPEncoding = JSON
+		}
+
+		var client *http.Client
+		if c.tls != nil {
+			tr := http.DefaultTransport.(*http.Transport).Clone()
+			tr.TLSClientConfig = c.tls
+			client = &http.Client{Transport: tr}
+		}
+		// The URL already includes the logs path, or is the logs specific
+		// endpoint which is used as is.
+		u, err := parseHTTPEndpoint(c.httpURL())
+		if err != nil {
+			return logr.Discard(), err
+		}
+		exp := newHTTPExporterURL(client, u, opts.HTTPEncoding, opts.Compression)
+		return NewWithExporter(exp, opts), nil
+	}
+	return logr.Discard(), fmt.Errorf("otlpr: unsupported OTLP protocol: %q", c.protocol)
+}
+
+// envConfig is the configuration read from environment variables.
+type envConfig struct {
+	protocol string
+	endpoint string
+	// logsEndpoint is true if endpoint is from the logs specific variable.
+	logsEndpoint bool
+	insecure     bool
+	tls          *tls.Config
+
+	headers     map[string]string
+	timeout     time.Duration
+	compression *Compression
+
+	scheduleDelay time.Duration
+	exportTimeout time.Duration
+	maxQueueSize  int
+	batchSize     int
+
+	// errs are the errors from parsing invalid values.
+	errs []error
+}
+
+// readEnv returns the envConfig read using getenv.
+func readEnv(getenv func(string) string) envConfig {
+	var c envConfig
+
+	// otlp returns the value of the OTEL_EXPORTER_OTLP_ variable with suffix,
+	// giving precedence to the logs specific one.
+	otlp := func(suffix string) (string, string, bool) {
+		for _, name := range []string{
+			"OTEL_EXPORTER_OTLP_LOGS_" + suffix,
+			"OTEL_EXPORTER_OTLP_" + suffix,
+		} {
+			if v := strings.TrimSpace(getenv(name)); v != "" {
+				return name, v, true
+			}
+		}
+		return "", "", false
+	}
+	millis := func(name, v string) time.Duration {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			c.errs = append(c.errs, fmt.Errorf("%s: invalid duration: %q", name, v))
+			return 0
+		}
+		return time.Duration(ms) * time.Millisecond
+	}
+	positive := func(name, v string) int {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.errs = append(c.errs, fmt.Errorf("%s: invalid size: %q", name, v))
+			return 0
+		}
+		return n
+	}
+
+	c.protocol = protocolGRPC
+	if _, v, ok := otlp("PROTOCOL"); ok {
+		c.protocol = v
+	}
+
+	var name string
+	var ok bool
+	if name, c.endpoint, ok = otlp("ENDPOINT"); ok {
+		c.logsEndpoint = strings.HasPrefix(name, "OTEL_EXPORTER_OTLP_LOGS_")
+	} else if c.protocol == protocolGRPC {
+		c.endpoint = defaultGRPCEndpoint
+	} else {
+		c.endpoint = defaultHTTPEndpoint
+	}
+
+	if name, v, ok := otlp("INSECURE"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.errs = append(c.errs, fmt.Errorf("%s: invalid boolean: %q", name, v))
+		}
+		c.insecure = b
+	}
+
+	if name, v, ok := otlp("CERTIFICATE"); ok {
+		conf, err := tlsConfig(v)
+		if err != nil {
+			c.errs = append(c.errs, fmt.Errorf("%s: %w", name, err))
+		}
+		c.tls = conf
+	}
+
+	// General headers are overridden by the logs specific ones.
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_LOGS_HEADERS"} {
+		if v := strings.TrimSpace(getenv(name)); v != "" {
+			h, err := parseHeaders(v)
+			if err != nil {
+				c.errs = append(c.errs, fmt.Errorf("%s: %w", name, err))
+			}
+			if c.headers == nil {
+				c.headers = make(map[string]string)
+			}
+			maps.Copy(c.headers, h)
+		}
+	}
+
+	if name, v, ok := otlp("TIMEOUT"); ok {
+		c.timeout = millis(name, v)
+	}
+
+	if name, v, ok := otlp("COMPRESSION"); ok {
+		switch v {
+		case "gzip":
+			comp := GzipCompression
+			c.compression = &comp
+		case "none":
+			comp := NoCompression
+			c.compression = &comp
+		default:
+			c.errs = append(c.errs, fmt.Errorf("%s: unsupported compression: %q", name, v))
+		}
+	}
+
+	if v := strings.TrimSpace(getenv("OTEL_BLRP_SCHEDULE_DELAY")); v != "" {
+		c.scheduleDelay = millis("OTEL_BLRP_SCHEDULE_DELAY", v)
+	}
+	if v := strings.TrimSpace(getenv("OTEL_BLRP_EXPORT_TIMEOUT")); v != "" {
+		c.exportTimeout = millis("OTEL_BLRP_EXPORT_TIMEOUT", v)
+	}
+	if v := strings.TrimSpace(getenv("OTEL_BLRP_MAX_QUEUE_SIZE")); v != "" {
+		c.maxQueueSize = positive("OTEL_BLRP_MAX_QUEUE_SIZE", v)
+	}
+	if v := strings.TrimSpace(getenv("OTEL_BLRP_MAX_EXPORT_BATCH_SIZE")); v != "" {
+		c.batchSize = positive("OTEL_BLRP_MAX_EXPORT_BATCH_SIZE", v)
+	}
+
+	return c
+}
+
+// apply returns opts updated with the values set in c.
+func (c envConfig) apply(opts Options) Options {
+	if c.insecure || strings.HasPrefix(c.endpoint, "http://") {
+		opts.Connection.Insecure = true
+	}
+	if c.tls != nil {
+		opts.Connection.TLSConfig = c.tls
+	}
+	if len(c.headers) > 0 {
+		h := maps.Clone(opts.Headers)
+		if h == nil {
+			h = make(map[string]string, len(c.headers))
+		}
+		maps.Copy(h, c.headers)
+		opts.Headers = h
+	}
+	if c.exportTimeout > 0 {
+		opts.ExportTimeout = c.exportTimeout
+	}
+	if c.timeout > 0 {
+		opts.ExportTimeout = c.timeout
+	}
+	if c.compression != nil {
+		opts.Compression = *c.compression
+	}
+	if c.scheduleDelay > 0 {
+		opts.Batcher.Timeout = c.scheduleDelay
+	}
+	if c.batchSize > 0 {
+		opts.Batcher.Messages = uint64(c.batchSize)
+	}
+	if c.maxQueueSize > 0 {
+		size := uint64(c.maxQueueSize)
+		if opts.Batcher.Messages == 0 {
+			opts.Batcher.Messages = defaultMessages
+		}
+		// A batch cannot hold more messages than the queue.
+		opts.Batcher.Messages = min(opts.Batcher.Messages, size)
+		// Round up so the queue holds at least size messages.
+		opts.Batcher.MaxQueueSize = int((size + opts.Batcher.Messages - 1) / opts.Batcher.Messages)
+	}
+	return opts
+}
+
+// grpcTarget returns the gRPC target of the endpoint.
+func (c envConfig) grpcTarget() string {
+	for _, scheme := range []string{"http://", "https://"} {
+		if t, ok := strings.CutPrefix(c.endpoint, scheme); ok {
+			return strings.TrimSuffix(t, "/")
+		}
+	}
+	return c.endpoint
+}
+
+// httpURL returns the URL logs are sent to using OTLP/HTTP.
+func (c envConfig) httpURL() string {
+	if c.logsEndpoint {
+		return c.endpoint
+	}
+	u, err := url.Parse(c.endpoint)
+	if err != nil {
+		// Let the exporter report the invalid URL.
+		return c.endpoint
+	}
+	return u.JoinPath(defaultHTTPPath).String()
+}
+
+// parseHeaders parses a W3C Baggage formatted list of headers (e.g.
+// "key1=value1,key2=value2"). Values are URL decoded. Any valid headers are
+// returned along with an error for invalid ones.
+func parseHeaders(v string) (map[string]string, error) {
+	h := make(map[string]string)
+	var errs []error
+	for _, kv := range strings.Split(v, ",") {
+		k, val, ok := strings.Cut(kv, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			errs = append(errs, fmt.Errorf("invalid header: %q", kv))
+			continue
+		}
+		dk, err := url.PathUnescape(k)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid header: %q: %w", kv, err))
+			continue
+		}
+		dv, err := url.PathUnescape(strings.TrimSpace(val))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid header: %q: %w", kv, err))
+			continue
+		}
+		h[dk] = dv
+	}
+	return h, errors.Join(errs...)
+}
+
+// tlsConfig returns a TLS configuration that verifies servers using the PEM
+// encoded certificates in the file at path.
+func tlsConfig(path string) (*tls.Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %q", path)
+	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
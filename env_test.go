@@ -0,0 +1,198 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collpb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func getenv(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func TestReadEnvDefaults(t *testing.T) {
+	c := readEnv(getenv(nil))
+	assert.Empty(t, c.errs)
+	assert.Equal(t, protocolGRPC, c.protocol)
+	assert.Equal(t, "localhost:4317", c.grpcTarget())
+
+	opts := c.apply(Options{ExportTimeout: time.Second})
+	assert.Equal(t, Options{
+		ExportTimeout: time.Second,
+		Connection:    Connection{Insecure: true},
+	}, opts)
+
+	c = readEnv(getenv(map[string]string{
+		"OTEL_EXPORTER_OTLP_PROTOCOL": "http/protobuf",
+	}))
+	assert.Equal(t, "http://localhost:4318/v1/logs", c.httpURL())
+}
+
+func TestReadEnv(t *testing.T) {
+	c := readEnv(getenv(map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT":         "https://collector:4317",
+		"OTEL_EXPORTER_OTLP_HEADERS":          "api-key=secret,X-Scope-OrgID=general",
+		"OTEL_EXPORTER_OTLP_LOGS_HEADERS":     "X-Scope-OrgID=tenant%201",
+		"OTEL_EXPORTER_OTLP_TIMEOUT":          "5000",
+		"OTEL_EXPORTER_OTLP_LOGS_TIMEOUT":     "2000",
+		"OTEL_EXPORTER_OTLP_LOGS_COMPRESSION": "gzip",
+		"OTEL_BLRP_SCHEDULE_DELAY":            "500",
+		"OTEL_BLRP_EXPORT_TIMEOUT":            "30000",
+		"OTEL_BLRP_MAX_QUEUE_SIZE":            "100",
+		"OTEL_BLRP_MAX_EXPORT_BATCH_SIZE":     "10",
+	}))
+	require.Empty(t, c.errs)
+	assert.Equal(t, "collector:4317", c.grpcTarget())
+
+	opts := c.apply(Options{Headers: map[string]string{"static": "value"}})
+	assert.Equal(t, Options{
+		Batcher: Batcher{
+			Messages:     10,
+			Timeout:      500 * time.Millisecond,
+			MaxQueueSize: 10,
+		},
+		ExportTimeout: 2 * time.Second,
+		Compression:   GzipCompression,
+		Headers: map[string]string{
+			"static":        "value",
+			"api-key":       "secret",
+			"X-Scope-OrgID": "tenant 1",
+		},
+	}, opts)
+}
+
+func TestReadEnvHTTPEndpoint(t *testing.T) {
+	c := readEnv(getenv(map[string]string{
+		"OTEL_EXPORTER_OTLP_PROTOCOL": "http/json",
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "https://collector:4318/base",
+	}))
+	assert.Equal(t, "https://collector:4318/base/v1/logs", c.httpURL())
+
+	c = readEnv(getenv(map[string]string{
+		"OTEL_EXPORTER_OTLP_PROTOCOL":      "http/json",
+		"OTEL_EXPORTER_OTLP_ENDPOINT":      "https://collector:4318/base",
+		"OTEL_EXPORTER_OTLP_LOGS_ENDPOINT": "https://logs:4318/custom",
+	}))
+	assert.Equal(t, "https://logs:4318/custom", c.httpURL())
+}
+
+func TestReadEnvInvalid(t *testing.T) {
+	c := readEnv(getenv(map[string]string{
+		"OTEL_EXPORTER_OTLP_HEADERS":      "valid=value,invalid",
+		"OTEL_EXPORTER_OTLP_INSECURE":     "maybe",
+		"OTEL_EXPORTER_OTLP_TIMEOUT":      "-1",
+		"OTEL_EXPORTER_OTLP_COMPRESSION":  "zstd",
+		"OTEL_EXPORTER_OTLP_CERTIFICATE":  "does-not-exist.pem",
+		"OTEL_BLRP_MAX_EXPORT_BATCH_SIZE": "0",
+	}))
+	assert.Len(t, c.errs, 6)
+	assert.Equal(t, map[string]string{"valid": "value"}, c.headers)
+
+	opts := c.apply(Options{ExportTimeout: time.Second})
+	assert.Equal(t, time.Second, opts.ExportTimeout)
+	assert.Equal(t, NoCompression, opts.Compression)
+	assert.Zero(t, opts.Batcher.Messages)
+}
+
+func TestReadEnvQueueSize(t *testing.T) {
+	batcher := func(env map[string]string) Batcher {
+		c := readEnv(getenv(env))
+		require.Empty(t, c.errs)
+		return c.apply(Options{}).Batcher
+	}
+
+	b := batcher(map[string]string{
+		"OTEL_BLRP_MAX_QUEUE_SIZE":        "2048",
+		"OTEL_BLRP_MAX_EXPORT_BATCH_SIZE": "500",
+	})
+	assert.Equal(t, Batcher{Messages: 500, MaxQueueSize: 5}, b, "rounded up")
+
+	b = batcher(map[string]string{"OTEL_BLRP_MAX_QUEUE_SIZE": "4096"})
+	assert.Equal(t, Batcher{Messages: defaultMessages, MaxQueueSize: 2}, b, "default batch size")
+
+	b = batcher(map[string]string{"OTEL_BLRP_MAX_EXPORT_BATCH_SIZE": "512"})
+	assert.Equal(t, Batcher{Messages: 512}, b, "default queue size")
+
+	b = batcher(map[string]string{
+		"OTEL_BLRP_MAX_QUEUE_SIZE":        "100",
+		"OTEL_BLRP_MAX_EXPORT_BATCH_SIZE": "512",
+	})
+	assert.Equal(t, Batcher{Messages: 100, MaxQueueSize: 1}, b, "batch larger than queue")
+}
+
+func TestNewFromEnvHTTP(t *testing.T) {
+	got := make(chan *collpb.ExportLogsServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/logs", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Api-Key"))
+
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		in := new(collpb.ExportLogsServiceRequest)
+		assert.NoError(t, protojson.Unmarshal(body, in))
+		got <- in
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret")
+
+	l, err := NewFromEnv(Options{})
+	require.NoError(t, err)
+	l.Info("message")
+	require.NoError(t, Shutdown(context.Background(), l))
+
+	req := <-got
+	assert.Equal(t, "message", req.ResourceLogs[0].ScopeLogs[0].LogRecords[0].Body.GetStringValue())
+}
+
+func TestNewFromEnvHTTPLogsEndpoint(t *testing.T) {
+	paths := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+	}))
+	t.Cleanup(srv.Close)
+
+	// The logs specific endpoint is used as is, without the default path.
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf")
+	t.Setenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", srv.URL)
+
+	l, err := NewFromEnv(Options{})
+	require.NoError(t, err)
+	l.Info("message")
+	require.NoError(t, Shutdown(context.Background(), l))
+	assert.Equal(t, "/", <-paths)
+}
+
+func TestNewFromEnvUnsupportedProtocol(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "carrier-pigeon")
+
+	l, err := NewFromEnv(Options{})
+	assert.Error(t, err)
+	assert.Equal(t, logr.Discard(), l)
+}
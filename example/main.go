@@ -111,6 +111,11 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer func() {
+		if err := otlpr.Shutdown(context.Background(), logger); err != nil {
+			log.Print(err)
+		}
+	}()
 	var span trace.Span
 	ctx, span = tracer.Start(ctx, "main")
 	defer span.End()
@@ -0,0 +1,138 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	collpb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// Exporter transmits OTLP log data to a destination.
+//
+// Implementations need to be safe for concurrent use.
+//
+// Exporters that buffer log data can also implement a
+// ForceFlush(context.Context) error method. It is called by Flush, after all
+// buffered log records have been exported, to wait for the data to be
+// delivered.
+type Exporter interface {
+	// Export transmits the log data in rl to the destination. The passed
+	// slice and its contents must not be retained or modified after Export
+	// returns.
+	Export(ctx context.Context, rl []*lpb.ResourceLogs) error
+	// Shutdown releases any resources held by the Exporter. Export will not
+	// be called after Shutdown.
+	Shutdown(ctx context.Context) error
+}
+
+// NewGRPCExporter returns an Exporter that exports log data over conn using
+// OTLP/gRPC. Payloads are compressed with comp. The conn is expected to be
+// ready to use when passed, and it is not closed when the Exporter is shut
+// down. If conn is nil, nil is returned.
+func NewGRPCExporter(conn *grpc.ClientConn, comp Compression) Exporter {
+	if conn == nil {
+		return nil
+	}
+	return newGRPCExporter(conn, comp)
+}
+
+// Compression is the compression applied to exported payloads.
+type Compression int
+
+const (
+	// NoCompression sends payloads uncompressed.
+	NoCompression Compression = iota
+	// GzipCompression compresses payloads using gzip.
+	GzipCompression
+)
+
+type grpcExporter struct {
+	client   collpb.LogsServiceClient
+	callOpts []grpc.CallOption
+	// closer, if not nil, is the connection owned by the exporter. It is
+	// closed on shutdown.
+	closer io.Closer
+}
+
+func newGRPCExporter(conn *grpc.ClientConn, comp Compression) *grpcExporter {
+	e := &grpcExporter{client: collpb.NewLogsServiceClient(conn)}
+	if comp == GzipCompression {
+		e.callOpts = append(e.callOpts, grpc.UseCompressor(gzip.Name))
+	}
+	return e
+}
+
+var _ Exporter = (*grpcExporter)(nil)
+
+func (e *grpcExporter) Export(ctx context.Context, rl []*lpb.ResourceLogs) error {
+	resp, err := e.client.Export(ctx, &collpb.ExportLogsServiceRequest{
+		ResourceLogs: rl,
+	}, e.callOpts...)
+	if err != nil {
+		return err
+	}
+	return partialSuccess(resp)
+}
+
+func (e *grpcExporter) Shutdown(context.Context) error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// PartialSuccess is the error returned by an Exporter when the receiving
+// endpoint accepted the export, but reported that some or all of the log
+// records were rejected or that it has warnings about the export.
+type PartialSuccess struct {
+	// RejectedLogRecords is the number of log records rejected by the
+	// receiving endpoint.
+	RejectedLogRecords int64
+	// ErrorMessage is the developer-facing message provided by the receiving
+	// endpoint explaining the partial success.
+	ErrorMessage string
+}
+
+func (e *PartialSuccess) Error() string {
+	msg := e.ErrorMessage
+	if msg == "" {
+		msg = "empty message"
+	}
+	return fmt.Sprintf("OTLP partial success: %s (%d log records rejected)", msg, e.RejectedLogRecords)
+}
+
+// partialSuccess returns a *PartialSuccess error if resp contains a partial
+// success. Otherwise, nil is returned.
+func partialSuccess(resp *collpb.ExportLogsServiceResponse) error {
+	ps := resp.GetPartialSuccess()
+	if ps.GetRejectedLogRecords() == 0 && ps.GetErrorMessage() == "" {
+		return nil
+	}
+	return &PartialSuccess{
+		RejectedLogRecords: ps.GetRejectedLogRecords(),
+		ErrorMessage:       ps.GetErrorMessage(),
+	}
+}
+
+// flusher is an Exporter that buffers log data.
+type flusher interface {
+	ForceFlush(context.Context) error
+}
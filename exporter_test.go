@@ -0,0 +1,115 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collpb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type logsServer struct {
+	collpb.UnimplementedLogsServiceServer
+
+	requests chan *collpb.ExportLogsServiceRequest
+}
+
+func (s *logsServer) Export(_ context.Context, req *collpb.ExportLogsServiceRequest) (*collpb.ExportLogsServiceResponse, error) {
+	s.requests <- req
+	return &collpb.ExportLogsServiceResponse{}, nil
+}
+
+// newGRPCConn returns a client connection to an in-memory OTLP/gRPC receiver
+// that sends the requests it receives to srv.requests. All calls made with
+// the connection are passed to intercept.
+func newGRPCConn(t *testing.T, srv *logsServer, intercept grpc.UnaryClientInterceptor) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	collpb.RegisterLogsServiceServer(s, srv)
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(intercept),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestGRPCExporterGzip(t *testing.T) {
+	var compressor string
+	intercept := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		for _, o := range opts {
+			if c, ok := o.(grpc.CompressorCallOption); ok {
+				compressor = c.CompressorType
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	srv := &logsServer{requests: make(chan *collpb.ExportLogsServiceRequest, 1)}
+	conn := newGRPCConn(t, srv, intercept)
+
+	rl := []*lpb.ResourceLogs{{SchemaUrl: "test"}}
+	require.NoError(t, NewGRPCExporter(conn, GzipCompression).Export(context.Background(), rl))
+	assert.Equal(t, gzip.Name, compressor)
+	assert.Equal(t, "test", (<-srv.requests).ResourceLogs[0].SchemaUrl)
+
+	compressor = ""
+	require.NoError(t, NewGRPCExporter(conn, NoCompression).Export(context.Background(), rl))
+	assert.Empty(t, compressor)
+	<-srv.requests
+}
+
+func TestPartialSuccess(t *testing.T) {
+	assert.NoError(t, partialSuccess(nil))
+	assert.NoError(t, partialSuccess(&collpb.ExportLogsServiceResponse{}))
+	assert.NoError(t, partialSuccess(&collpb.ExportLogsServiceResponse{
+		PartialSuccess: &collpb.ExportLogsPartialSuccess{},
+	}))
+
+	err := partialSuccess(&collpb.ExportLogsServiceResponse{
+		PartialSuccess: &collpb.ExportLogsPartialSuccess{
+			RejectedLogRecords: 2,
+			ErrorMessage:       "invalid records",
+		},
+	})
+	assert.Equal(t, &PartialSuccess{
+		RejectedLogRecords: 2,
+		ErrorMessage:       "invalid records",
+	}, err)
+	assert.EqualError(t, err, "OTLP partial success: invalid records (2 log records rejected)")
+
+	err = partialSuccess(&collpb.ExportLogsServiceResponse{
+		PartialSuccess: &collpb.ExportLogsPartialSuccess{ErrorMessage: "warning"},
+	})
+	assert.Equal(t, &PartialSuccess{ErrorMessage: "warning"}, err)
+}
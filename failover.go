@@ -0,0 +1,261 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults for Failover.
+const (
+	defaultUnavailableThreshold = 30 * time.Second
+	defaultProbeInterval        = time.Minute
+)
+
+// Failover defines when a failover Exporter (see NewFailoverExporter)
+// switches between its endpoints.
+type Failover struct {
+	// UnavailableThreshold is how long an endpoint is allowed to keep
+	// failing with retryable errors (e.g. it is unavailable) before the next
+	// endpoint is used.
+	//
+	// If UnavailableThreshold is less than or equal to zero, the default
+	// value of 30 seconds is used.
+	UnavailableThreshold time.Duration
+
+	// ProbeInterval is how often the primary endpoint is tried again once
+	// another endpoint is in use. The probe is done with the next export, and
+	// if it succeeds the primary endpoint is used from then on.
+	//
+	// If ProbeInterval is less than or equal to zero, the default value of 1
+	// minute is used.
+	ProbeInterval time.Duration
+}
+
+// NewFailoverExporter returns an Exporter that exports to the first of
+// exporters, the primary endpoint, and fails over to the next one in order
+// when the endpoint in use fails. Nil exporters are ignored. If no exporters
+// are passed, nil is returned.
+//
+// An endpoint is failed over immediately if it rejects the export because of
+// how it is configured (e.g. it is unauthenticated or does not implement the
+// OTLP logs service), and after conf.UnavailableThreshold if it keeps failing
+// with retryable errors. The export that caused the failover is sent to the
+// next endpoint before Export returns. Other errors, like rejected log data,
+// do not cause a failover.
+//
+// While a secondary endpoint is in use, the primary is probed every
+// conf.ProbeInterval to return to it once it recovers. The probe is limited
+// to half the time remaining before the deadline of the export context, so
+// an unresponsive primary leaves time to export to the active endpoint.
+//
+// To fail over between gRPC connections, wrap each with NewGRPCExporter.
+func NewFailoverExporter(conf Failover, exporters ...Exporter) Exporter {
+	if conf.UnavailableThreshold <= 0 {
+		conf.UnavailableThreshold = defaultUnavailableThreshold
+	}
+	if conf.ProbeInterval <= 0 {
+		conf.ProbeInterval = defaultProbeInterval
+	}
+
+	e := &failoverExporter{conf: conf, now: time.Now}
+	for _, exp := range exporters {
+		if exp != nil {
+			e.exporters = append(e.exporters, exp)
+		}
+	}
+	if len(e.exporters) == 0 {
+		return nil
+	}
+	return e
+}
+
+type failoverExporter struct {
+	conf      Failover
+	exporters []Exporter
+	now       func() time.Time
+
+	mu sync.Mutex
+	// active is the index of the exporter in use.
+	active int
+	// failing is when the active exporter started failing. It is zero if the
+	// last export to it succeeded.
+	failing time.Time
+	// probed is when the primary exporter was last tried.
+	probed time.Time
+}
+
+var _ Exporter = (*failoverExporter)(nil)
+
+func (e *failoverExporter) Export(ctx context.Context, rl []*lpb.ResourceLogs) error {
+	if e.probe() {
+		pCtx, cancel := probeContext(ctx)
+		err := e.exporters[0].Export(pCtx, rl)
+		cancel()
+		if succeeded(err) {
+			e.mu.Lock()
+			e.active, e.failing = 0, time.Time{}
+			e.mu.Unlock()
+			return err
+		}
+		// The primary has not recovered, use the active exporter.
+	}
+
+	e.mu.Lock()
+	i := e.active
+	e.mu.Unlock()
+
+	var err error
+	for range e.exporters {
+		err = e.exporters[i].Export(ctx, rl)
+		var failover bool
+		if i, failover = e.record(i, err); !failover || ctx.Err() != nil {
+			break
+		}
+	}
+	return err
+}
+
+// probe returns if the primary exporter needs to be probed.
+func (e *failoverExporter) probe() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	if e.active == 0 || now.Sub(e.probed) < e.conf.ProbeInterval {
+		return false
+	}
+	e.probed = now
+	return true
+}
+
+// probeContext returns the context used to probe the primary exporter. If ctx
+// has a deadline, the probe is given half of the time remaining before it.
+func probeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/2)
+}
+
+// record updates the health of the exporter at index i based on the err
+// returned from exporting to it. It returns the index of the exporter to use
+// next and if the export needs to be sent to it.
+func (e *failoverExporter) record(i int, err error) (int, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if i != e.active {
+		// A concurrent export already failed over.
+		return e.active, !succeeded(err) && unhealthy(err)
+	}
+
+	now := e.now()
+	switch {
+	case succeeded(err):
+		e.failing = time.Time{}
+		return i, false
+	case rejectedByEndpoint(err):
+	case unhealthy(err):
+		if e.failing.IsZero() {
+			e.failing = now
+		}
+		if now.Sub(e.failing) < e.conf.UnavailableThreshold {
+			return i, false
+		}
+	default:
+		return i, false
+	}
+
+	e.active = (i + 1) % len(e.exporters)
+	e.failing = time.Time{}
+	if e.active != 0 {
+		e.probed = now
+	}
+	return e.active, true
+}
+
+// ForceFlush flushes all exporters that buffer log data.
+func (e *failoverExporter) ForceFlush(ctx context.Context) error {
+	var errs []error
+	for _, exp := range e.exporters {
+		if f, ok := exp.(flusher); ok {
+			errs = append(errs, f.ForceFlush(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown shuts down all exporters.
+func (e *failoverExporter) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, exp := range e.exporters {
+		errs = append(errs, exp.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// succeeded returns if err is from an export the receiving endpoint accepted.
+func succeeded(err error) bool {
+	var ps *PartialSuccess
+	return err == nil || errors.As(err, &ps)
+}
+
+// unhealthy returns if err is from an export that failed because the
+// receiving endpoint is unavailable or was unable to handle it.
+func unhealthy(err error) bool {
+	if rejectedByEndpoint(err) {
+		return true
+	}
+	ok, _ := retryable(err)
+	return ok
+}
+
+// rejectedByEndpoint returns if err is from an export that failed because
+// the receiving endpoint is not able to accept any exports from this client.
+func rejectedByEndpoint(err error) bool {
+	var hErr *httpError
+	if errors.As(err, &hErr) {
+		switch hErr.status {
+		case http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusMethodNotAllowed:
+			return true
+		}
+		return false
+	}
+
+	s, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	switch s.Code() {
+	case codes.Unimplemented,
+		codes.Unauthenticated,
+		codes.PermissionDenied:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newFailover returns a failoverExporter for exporters that uses a clock
+// controlled by the returned function.
+func newFailover(t *testing.T, exporters ...Exporter) (*failoverExporter, func(time.Duration)) {
+	t.Helper()
+
+	conf := Failover{UnavailableThreshold: time.Minute, ProbeInterval: time.Hour}
+	e, ok := NewFailoverExporter(conf, exporters...).(*failoverExporter)
+	require.True(t, ok)
+
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	return e, func(d time.Duration) { now = now.Add(d) }
+}
+
+// exported returns the number of exports received by exp.
+func exported(exp *testExporter) int {
+	n := len(exp.exports)
+	for range n {
+		<-exp.exports
+	}
+	return n
+}
+
+func TestNewFailoverExporterNil(t *testing.T) {
+	assert.Nil(t, NewFailoverExporter(Failover{}))
+	assert.Nil(t, NewFailoverExporter(Failover{}, nil))
+}
+
+func TestFailoverRejectedByEndpoint(t *testing.T) {
+	primary, secondary := newTestExporter(10), newTestExporter(10)
+	primary.err = status.Error(codes.Unauthenticated, "no credentials")
+	exp, _ := newFailover(t, primary, nil, secondary)
+
+	ctx := context.Background()
+	require.NoError(t, exp.Export(ctx, []*lpb.ResourceLogs{{}}))
+	assert.Equal(t, 1, exported(primary))
+	assert.Equal(t, 1, exported(secondary))
+
+	require.NoError(t, exp.Export(ctx, []*lpb.ResourceLogs{{}}))
+	assert.Equal(t, 0, exported(primary))
+	assert.Equal(t, 1, exported(secondary))
+}
+
+func TestFailoverUnavailableThreshold(t *testing.T) {
+	primary, secondary := newTestExporter(10), newTestExporter(10)
+	primary.err = &httpError{err: errors.New("unavailable"), retry: true}
+	exp, advance := newFailover(t, primary, secondary)
+
+	ctx := context.Background()
+	assert.ErrorIs(t, exp.Export(ctx, nil), primary.err)
+	advance(30 * time.Second)
+	assert.ErrorIs(t, exp.Export(ctx, nil), primary.err)
+	assert.Equal(t, 2, exported(primary))
+	assert.Equal(t, 0, exported(secondary))
+
+	advance(30 * time.Second)
+	require.NoError(t, exp.Export(ctx, nil))
+	assert.Equal(t, 1, exported(primary))
+	assert.Equal(t, 1, exported(secondary))
+}
+
+func TestFailoverRecovery(t *testing.T) {
+	primary, secondary := newTestExporter(10), newTestExporter(10)
+	primary.err = status.Error(codes.Unavailable, "unavailable")
+	exp, advance := newFailover(t, primary, secondary)
+
+	// A successful export resets the threshold.
+	ctx := context.Background()
+	assert.Error(t, exp.Export(ctx, nil))
+	primary.err = nil
+	advance(time.Minute)
+	require.NoError(t, exp.Export(ctx, nil))
+	primary.err = status.Error(codes.Unavailable, "unavailable")
+	advance(time.Minute)
+	assert.Error(t, exp.Export(ctx, nil))
+	assert.Equal(t, 3, exported(primary))
+	assert.Equal(t, 0, exported(secondary))
+}
+
+func TestFailoverProbe(t *testing.T) {
+	primary, secondary := newTestExporter(10), newTestExporter(10)
+	primary.err = &httpError{err: errors.New("not found"), status: http.StatusNotFound}
+	exp, advance := newFailover(t, primary, secondary)
+
+	ctx := context.Background()
+	require.NoError(t, exp.Export(ctx, nil))
+	assert.Equal(t, 1, exported(primary))
+	assert.Equal(t, 1, exported(secondary))
+
+	// The primary is still failing when probed.
+	advance(time.Hour)
+	require.NoError(t, exp.Export(ctx, nil))
+	require.NoError(t, exp.Export(ctx, nil))
+	assert.Equal(t, 1, exported(primary))
+	assert.Equal(t, 2, exported(secondary))
+
+	primary.err = nil
+	advance(time.Hour)
+	require.NoError(t, exp.Export(ctx, nil))
+	require.NoError(t, exp.Export(ctx, nil))
+	assert.Equal(t, 2, exported(primary))
+	assert.Equal(t, 0, exported(secondary))
+}
+
+func TestFailoverProbeHungPrimary(t *testing.T) {
+	secondary := newTestExporter(10)
+	exp, advance := newFailover(t, blockingExporter{}, secondary)
+	exp.active = 1
+
+	advance(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, exp.Export(ctx, nil))
+	assert.NoError(t, ctx.Err(), "probe used the whole export deadline")
+	assert.Equal(t, 1, exported(secondary))
+	assert.Equal(t, 1, exp.active)
+}
+
+func TestFailoverDataErrors(t *testing.T) {
+	primary, secondary := newTestExporter(10), newTestExporter(10)
+	primary.err = status.Error(codes.InvalidArgument, "bad data")
+	exp, _ := newFailover(t, primary, secondary)
+
+	assert.ErrorIs(t, exp.Export(context.Background(), nil), primary.err)
+	assert.Equal(t, 1, exported(primary))
+	assert.Equal(t, 0, exported(secondary))
+}
+
+func TestFailoverAllEndpointsFail(t *testing.T) {
+	primary, secondary := newTestExporter(10), newTestExporter(10)
+	primary.err = status.Error(codes.PermissionDenied, "denied")
+	secondary.err = status.Error(codes.Unimplemented, "unimplemented")
+	exp, _ := newFailover(t, primary, secondary)
+
+	assert.ErrorIs(t, exp.Export(context.Background(), nil), secondary.err)
+	assert.Equal(t, 1, exported(primary))
+	assert.Equal(t, 1, exported(secondary))
+}
+
+func TestFailoverShutdown(t *testing.T) {
+	primary, secondary := newTestExporter(0), newTestExporter(0)
+	exp := NewFailoverExporter(Failover{}, primary, secondary)
+	require.NoError(t, exp.Shutdown(context.Background()))
+	<-primary.shutdown
+	<-secondary.shutdown
+}
@@ -0,0 +1,353 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	collpb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultHTTPPath is the URL path logs are sent to when the endpoint passed
+// to NewHTTP does not include one.
+const defaultHTTPPath = "/v1/logs"
+
+// Encoding is the payload encoding used for OTLP/HTTP exports.
+type Encoding int
+
+const (
+	// Protobuf encodes payloads as binary protobuf.
+	Protobuf Encoding = iota
+	// JSON encodes payloads using the OTLP/HTTP JSON encoding.
+	JSON
+)
+
+// NewHTTP returns a new logr Logger that will export logs to endpoint using
+// OTLP/HTTP with binary protobuf encoding.
+//
+// The endpoint is the URL of the OTLP/HTTP receiver (e.g.
+// "http://localhost:4318"). If the endpoint URL does not include a path, the
+// default "/v1/logs" path is used. If endpoint is not a valid URL a discard
+// logger is returned.
+//
+// Requests are sent using client. If client is nil, http.DefaultClient is
+// used.
+func NewHTTP(client *http.Client, endpoint string) logr.Logger {
+	return NewHTTPWithOptions(client, endpoint, Options{})
+}
+
+// NewHTTPWithOptions returns a new logr Logger that will export logs to
+// endpoint using OTLP/HTTP. The payload encoding is determined by
+// opts.HTTPEncoding. See NewHTTP for details.
+func NewHTTPWithOptions(client *http.Client, endpoint string, opts Options) logr.Logger {
+	exp, err := newHTTPExporter(client, endpoint, opts.HTTPEncoding, opts.Compression)
+	if err != nil {
+		return logr.Discard()
+	}
+	return NewWithExporter(exp, opts)
+}
+
+// NewHTTPExporter returns an Exporter that exports log data to endpoint using
+// OTLP/HTTP with the enc payload encoding and comp compression. See NewHTTP
+// for details about client and endpoint. An error is returned if endpoint is not a valid URL.
+//
+// Any outgoing gRPC metadata in the context passed to Export (see
+// google.golang.org/grpc/metadata) is sent as HTTP headers.
+func NewHTTPExporter(client *http.Client, endpoint string, enc Encoding, comp Compression) (Exporter, error) {
+	exp, err := newHTTPExporter(client, endpoint, enc, comp)
+	if err != nil {
+		return nil, err
+	}
+	return exp, nil
+}
+
+type httpExporter struct {
+	client *http.Client
+	url    string
+	enc    Encoding
+	comp   Compression
+}
+
+var _ Exporter = (*httpExporter)(nil)
+
+func newHTTPExporter(client *http.Client, endpoint string, enc Encoding, comp Compression) (*httpExporter, error) {
+	u, err := parseHTTPEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultHTTPPath
+	}
+	return newHTTPExporterURL(client, u, enc, comp), nil
+}
+
+// newHTTPExporterURL returns an httpExporter that sends to u as is. An empty
+// path is sent as the root path.
+func newHTTPExporterURL(client *http.Client, u *url.URL, enc Encoding, comp Compression) *httpExporter {
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpExporter{client: client, url: u.String(), enc: enc, comp: comp}
+}
+
+// parseHTTPEndpoint parses the URL of an OTLP/HTTP receiver.
+func parseHTTPEndpoint(endpoint string) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP/HTTP endpoint: %q", endpoint)
+	}
+	return u, nil
+}
+
+func (e *httpExporter) Export(ctx context.Context, rl []*lpb.ResourceLogs) error {
+	resp, err := e.export(ctx, &collpb.ExportLogsServiceRequest{ResourceLogs: rl})
+	if err != nil {
+		return err
+	}
+	return partialSuccess(resp)
+}
+
+func (e *httpExporter) Shutdown(context.Context) error { return nil }
+
+// export sends req to the OTLP/HTTP receiver.
+func (e *httpExporter) export(ctx context.Context, req *collpb.ExportLogsServiceRequest) (*collpb.ExportLogsServiceResponse, error) {
+	body, err := e.marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if e.comp == GzipCompression {
+		if body, err = gzipBytes(body); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", e.contentType())
+	if e.comp == GzipCompression {
+		r.Header.Set("Content-Encoding", "gzip")
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for k, vals := range md {
+		for _, v := range vals {
+			r.Header.Add(k, v)
+		}
+	}
+
+	resp, err := e.client.Do(r)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		// The OTLP specification requires connection failures to be retried.
+		return nil, &httpError{err: err, retry: true}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := resp.Status
+		// Failed requests may contain a google.rpc.Status describing the
+		// failure. Use its message if one can be decoded.
+		s := new(spb.Status)
+		if e.unmarshal(data, s) == nil && s.GetMessage() != "" {
+			msg = fmt.Sprintf("%s: %s", msg, s.GetMessage())
+		}
+		hErr := &httpError{
+			err:    fmt.Errorf("OTLP/HTTP export failed: %s", msg),
+			status: resp.StatusCode,
+		}
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			hErr.retry = true
+			hErr.throttle = retryAfter(resp.Header.Get("Retry-After"))
+		}
+		return nil, hErr
+	}
+
+	out := new(collpb.ExportLogsServiceResponse)
+	if err := e.unmarshal(data, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (e *httpExporter) contentType() string {
+	if e.enc == JSON {
+		return "application/json"
+	}
+	return "application/x-protobuf"
+}
+
+func (e *httpExporter) marshal(req *collpb.ExportLogsServiceRequest) ([]byte, error) {
+	if e.enc == JSON {
+		return marshalJSON(req)
+	}
+	return proto.Marshal(req)
+}
+
+func (e *httpExporter) unmarshal(data []byte, m proto.Message) error {
+	if e.enc == JSON {
+		if len(data) == 0 {
+			// An empty body is a valid, empty, response.
+			return nil
+		}
+		return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, m)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// marshalJSON returns req encoded using the OTLP/HTTP JSON encoding.
+//
+// This differs from the canonical protobuf JSON mapping in that enums are
+// encoded as integers and trace and span IDs are hex encoded strings instead
+// of base64.
+func marshalJSON(req *collpb.ExportLogsServiceRequest) ([]byte, error) {
+	data, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// Preserve numeric precision when re-encoding.
+	dec.UseNumber()
+	var msg map[string]interface{}
+	if err := dec.Decode(&msg); err != nil {
+		return nil, err
+	}
+
+	for _, rl := range jsonList(msg, "resourceLogs") {
+		for _, sl := range jsonList(rl, "scopeLogs") {
+			for _, lr := range jsonList(sl, "logRecords") {
+				if err := hexID(lr, "traceId"); err != nil {
+					return nil, err
+				}
+				if err := hexID(lr, "spanId"); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return json.Marshal(msg)
+}
+
+// jsonList returns the JSON objects contained in the list field key of obj.
+func jsonList(obj map[string]interface{}, key string) []map[string]interface{} {
+	list, _ := obj[key].([]interface{})
+	out := make([]map[string]interface{}, 0, len(list))
+	for _, v := range list {
+		if m, ok := v.(map[string]interface{}); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// hexID re-encodes the base64 encoded bytes field key of obj as hex.
+func hexID(obj map[string]interface{}, key string) error {
+	v, ok := obj[key].(string)
+	if !ok {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return err
+	}
+	obj[key] = hex.EncodeToString(b)
+	return nil
+}
+
+// httpError is an error returned from an OTLP/HTTP export.
+type httpError struct {
+	err      error
+	retry    bool
+	throttle time.Duration
+	// status is the HTTP status code of the response. It is zero if no
+	// response was received.
+	status int
+}
+
+var _ retryableError = (*httpError)(nil)
+
+func (e *httpError) Error() string { return e.err.Error() }
+
+func (e *httpError) Unwrap() error { return e.err }
+
+func (e *httpError) Retryable() (bool, time.Duration) { return e.retry, e.throttle }
+
+// gzipBytes returns b compressed using gzip.
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// retryAfter returns the duration to wait based on the value of a
+// Retry-After header. Zero is returned if the value is empty or invalid.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		if sec < 0 {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
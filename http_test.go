@@ -0,0 +1,210 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collpb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNewHTTPExporterEndpoint(t *testing.T) {
+	e, err := newHTTPExporter(nil, "http://localhost:4318", Protobuf, NoCompression)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:4318/v1/logs", e.url)
+	assert.Equal(t, http.DefaultClient, e.client)
+
+	e, err = newHTTPExporter(nil, "https://localhost/custom/path", Protobuf, NoCompression)
+	require.NoError(t, err)
+	assert.Equal(t, "https://localhost/custom/path", e.url)
+
+	_, err = newHTTPExporter(nil, "localhost:4318", Protobuf, NoCompression)
+	assert.Error(t, err)
+
+	exp, err := NewHTTPExporter(nil, "localhost:4318", Protobuf, NoCompression)
+	assert.Error(t, err)
+	assert.True(t, exp == nil, "non-nil Exporter returned")
+}
+
+func TestHTTPExporterExport(t *testing.T) {
+	req := &collpb.ExportLogsServiceRequest{
+		ResourceLogs: []*lpb.ResourceLogs{{
+			ScopeLogs: []*lpb.ScopeLogs{{
+				LogRecords: []*lpb.LogRecord{{SeverityText: "INFO"}},
+			}},
+		}},
+	}
+
+	got := make(chan *collpb.ExportLogsServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, defaultHTTPPath, r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		in := new(collpb.ExportLogsServiceRequest)
+		assert.NoError(t, proto.Unmarshal(body, in))
+		got <- in
+
+		resp, err := proto.Marshal(&collpb.ExportLogsServiceResponse{})
+		assert.NoError(t, err)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	e, err := newHTTPExporter(srv.Client(), srv.URL, Protobuf, NoCompression)
+	require.NoError(t, err)
+
+	_, err = e.export(context.Background(), req)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(req, <-got))
+}
+
+func TestHTTPExporterGzip(t *testing.T) {
+	req := &collpb.ExportLogsServiceRequest{
+		ResourceLogs: []*lpb.ResourceLogs{{SchemaUrl: "test"}},
+	}
+
+	got := make(chan *collpb.ExportLogsServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+
+		zr, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		assert.NoError(t, err)
+		in := new(collpb.ExportLogsServiceRequest)
+		assert.NoError(t, proto.Unmarshal(body, in))
+		got <- in
+	}))
+	t.Cleanup(srv.Close)
+
+	e, err := NewHTTPExporter(srv.Client(), srv.URL, Protobuf, GzipCompression)
+	require.NoError(t, err)
+
+	require.NoError(t, e.Export(context.Background(), req.ResourceLogs))
+	assert.True(t, proto.Equal(req, <-got))
+}
+
+func TestHTTPExporterHeaders(t *testing.T) {
+	got := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header
+	}))
+	t.Cleanup(srv.Close)
+
+	e, err := newHTTPExporter(srv.Client(), srv.URL, Protobuf, NoCompression)
+	require.NoError(t, err)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "X-Scope-OrgID", "tenant")
+	_, err = e.export(ctx, &collpb.ExportLogsServiceRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "tenant", (<-got).Get("X-Scope-OrgID"))
+}
+
+func TestHTTPExporterExportFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	t.Cleanup(srv.Close)
+
+	e, err := newHTTPExporter(srv.Client(), srv.URL, Protobuf, NoCompression)
+	require.NoError(t, err)
+
+	_, err = e.export(context.Background(), &collpb.ExportLogsServiceRequest{})
+	assert.ErrorContains(t, err, "400 Bad Request")
+}
+
+func TestMarshalJSON(t *testing.T) {
+	req := &collpb.ExportLogsServiceRequest{
+		ResourceLogs: []*lpb.ResourceLogs{{
+			ScopeLogs: []*lpb.ScopeLogs{{
+				LogRecords: []*lpb.LogRecord{{
+					TimeUnixNano:   1,
+					SeverityNumber: lpb.SeverityNumber_SEVERITY_NUMBER_INFO,
+					TraceId:        []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+					SpanId:         []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+				}},
+			}},
+		}},
+	}
+
+	got, err := marshalJSON(req)
+	require.NoError(t, err)
+
+	want := `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{` +
+		`"severityNumber":9,` +
+		`"spanId":"0102030405060708",` +
+		`"timeUnixNano":"1",` +
+		`"traceId":"0102030405060708090a0b0c0d0e0f10"` +
+		`}]}]}]}`
+	assert.JSONEq(t, want, string(got))
+}
+
+func TestHTTPExporterExportJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"partialSuccess":{"rejectedLogRecords":"2","errorMessage":"bad"}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	e, err := newHTTPExporter(srv.Client(), srv.URL, JSON, NoCompression)
+	require.NoError(t, err)
+
+	resp, err := e.export(context.Background(), &collpb.ExportLogsServiceRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.GetPartialSuccess().GetRejectedLogRecords())
+	assert.Equal(t, "bad", resp.GetPartialSuccess().GetErrorMessage())
+}
+
+func TestHTTPExporterRetryable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	e, err := newHTTPExporter(srv.Client(), srv.URL, Protobuf, NoCompression)
+	require.NoError(t, err)
+
+	_, err = e.export(context.Background(), &collpb.ExportLogsServiceRequest{})
+	ok, d := retryable(err)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), retryAfter(""))
+	assert.Equal(t, time.Duration(0), retryAfter("invalid"))
+	assert.Equal(t, time.Duration(0), retryAfter("-1"))
+	assert.Equal(t, 2*time.Second, retryAfter("2"))
+
+	d := retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.Greater(t, d, 59*time.Minute)
+}
@@ -58,6 +58,15 @@ type Options struct {
 	// contains a struct, etc.) to log. If this field is not specified, a
 	// default value, 16, will be used.
 	MaxLogDepth int
+
+	// NameKey, if not empty, is the attribute key used to log the logger
+	// name. The name is not logged if it is empty.
+	NameKey string
+
+	// LevelNames are the severity text used for info messages logged at
+	// each logr verbosity level. Levels without a name use the short name
+	// of their severity number (e.g. "WARN4").
+	LevelNames map[int]string
 }
 
 // MessageClass indicates which category or categories of messages to consider.
@@ -385,6 +394,24 @@ func (f Formatter) level(l int) lpb.SeverityNumber {
 	return lpb.SeverityNumber(int(lpb.SeverityNumber_SEVERITY_NUMBER_WARN4) - l)
 }
 
+// severityRanges are the short names of each range of severity numbers.
+var severityRanges = [...]string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+// severityText returns the short name of v (e.g. "INFO", "DEBUG2"), as
+// defined by the OpenTelemetry logs data model. An empty string is returned
+// if v is unspecified or not valid.
+func severityText(v lpb.SeverityNumber) string {
+	if v < lpb.SeverityNumber_SEVERITY_NUMBER_TRACE || v > lpb.SeverityNumber_SEVERITY_NUMBER_FATAL4 {
+		return ""
+	}
+	i := int(v) - 1
+	name := severityRanges[i/4]
+	if n := i%4 + 1; n > 1 {
+		name += strconv.Itoa(n)
+	}
+	return name
+}
+
 // Caller represents the original call site for a log line, after considering
 // logr.Logger.WithCallDepth and logr.Logger.WithCallStackHelper.  The File and
 // Line fields will always be provided, while the Func field is optional.
@@ -414,13 +441,20 @@ func (f Formatter) caller() Caller {
 	return Caller{filepath.Base(file), line, fn}
 }
 
-func (f Formatter) render(v lpb.SeverityNumber, body *cpb.AnyValue, kvList []interface{}) *lpb.LogRecord {
+func (f Formatter) render(v lpb.SeverityNumber, text string, body *cpb.AnyValue, kvList []interface{}) *lpb.LogRecord {
 	out := &lpb.LogRecord{
 		TimeUnixNano:   uint64(now().UnixNano()),
 		SeverityNumber: v,
+		SeverityText:   text,
 		Body:           body,
 		Attributes:     append(f.valuesAttr, f.attrs(kvList)...),
 	}
+	if f.opts.NameKey != "" && f.name != "" {
+		out.Attributes = append(out.Attributes, &cpb.KeyValue{
+			Key:   f.opts.NameKey,
+			Value: &cpb.AnyValue{Value: &cpb.AnyValue_StringValue{StringValue: f.name}},
+		})
+	}
 	if f.spanCtx.IsValid() {
 		tID := f.spanCtx.TraceID()
 		out.TraceId = tID[:]
@@ -474,7 +508,12 @@ func (f Formatter) FormatInfo(level int, msg string, kvList []interface{}) *lpb.
 	if policy := f.opts.LogCaller; policy == All || policy == Error {
 		kvList = append(kvList, "caller", f.caller())
 	}
-	return f.render(f.level(level), f.infoBody(msg), kvList)
+	v := f.level(level)
+	text, ok := f.opts.LevelNames[level]
+	if !ok {
+		text = severityText(v)
+	}
+	return f.render(v, text, f.infoBody(msg), kvList)
 }
 
 func (f Formatter) FormatError(err error, msg string, kvList []interface{}) *lpb.LogRecord {
@@ -482,7 +521,7 @@ func (f Formatter) FormatError(err error, msg string, kvList []interface{}) *lpb
 		kvList = append(kvList, "caller", f.caller())
 	}
 	const v = lpb.SeverityNumber_SEVERITY_NUMBER_ERROR
-	return f.render(v, f.errBody(err, msg), kvList)
+	return f.render(v, severityText(v), f.errBody(err, msg), kvList)
 }
 
 func (f Formatter) FormatResource(res *resource.Resource) (string, *rpb.Resource) {
@@ -511,6 +550,12 @@ func (f *Formatter) AddName(name string) {
 	f.name += name
 }
 
+// Name returns the name of the logger. Names added with AddName are
+// separated by "/".
+func (f Formatter) Name() string {
+	return f.name
+}
+
 // AddContext adds log values for the span in ctx if it exists.
 func (f *Formatter) AddContext(ctx context.Context) {
 	f.spanCtx = trace.SpanContextFromContext(ctx)
@@ -210,6 +210,7 @@ func TestFormatterFormatInfo(t *testing.T) {
 	want := &lpb.LogRecord{
 		TimeUnixNano:   uint64(staticTime.UnixNano()),
 		SeverityNumber: 14,
+		SeverityText:   "WARN2",
 		Body: &cpb.AnyValue{
 			Value: &cpb.AnyValue_StringValue{StringValue: "message"},
 		},
@@ -231,6 +232,7 @@ func TestFormatterFormatError(t *testing.T) {
 	want := &lpb.LogRecord{
 		TimeUnixNano:   uint64(staticTime.UnixNano()),
 		SeverityNumber: 17,
+		SeverityText:   "ERROR",
 		Body: &cpb.AnyValue{
 			Value: &cpb.AnyValue_KvlistValue{
 				KvlistValue: &cpb.KeyValueList{
@@ -328,6 +330,7 @@ func TestFormatterAddContext(t *testing.T) {
 	want := &lpb.LogRecord{
 		TimeUnixNano:   uint64(staticTime.UnixNano()),
 		SeverityNumber: 16,
+		SeverityText:   "WARN4",
 		Body: &cpb.AnyValue{
 			Value: &cpb.AnyValue_StringValue{StringValue: "message"},
 		},
@@ -347,6 +350,7 @@ func TestFormatterAddValues(t *testing.T) {
 	want := &lpb.LogRecord{
 		TimeUnixNano:   uint64(staticTime.UnixNano()),
 		SeverityNumber: 16,
+		SeverityText:   "WARN4",
 		Body: &cpb.AnyValue{
 			Value: &cpb.AnyValue_StringValue{StringValue: "message"},
 		},
@@ -361,3 +365,54 @@ func TestFormatterAddValues(t *testing.T) {
 	}
 	assert.Equal(t, want, got)
 }
+
+func TestFormatterAddName(t *testing.T) {
+	t.Cleanup(mockTime(now))
+
+	f := NewFormatter(Options{NameKey: "logger.name"})
+	got := f.FormatInfo(0, "message", nil)
+	assert.Empty(t, got.Attributes, "empty name logged")
+
+	f.AddName("parent")
+	f.AddName("child")
+	assert.Equal(t, "parent/child", f.Name())
+
+	got = f.FormatInfo(0, "message", []interface{}{"key", "value"})
+	want := []*cpb.KeyValue{
+		{Key: "key", Value: &cpb.AnyValue{
+			Value: &cpb.AnyValue_StringValue{StringValue: "value"},
+		}},
+		{Key: "logger.name", Value: &cpb.AnyValue{
+			Value: &cpb.AnyValue_StringValue{StringValue: "parent/child"},
+		}},
+	}
+	assert.Equal(t, want, got.Attributes)
+
+	f = NewFormatter(Options{})
+	f.AddName("name")
+	assert.Empty(t, f.FormatInfo(0, "message", nil).Attributes)
+}
+
+func TestSeverityText(t *testing.T) {
+	tests := map[lpb.SeverityNumber]string{
+		lpb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED: "",
+		lpb.SeverityNumber_SEVERITY_NUMBER_TRACE:       "TRACE",
+		lpb.SeverityNumber_SEVERITY_NUMBER_DEBUG2:      "DEBUG2",
+		lpb.SeverityNumber_SEVERITY_NUMBER_INFO:        "INFO",
+		lpb.SeverityNumber_SEVERITY_NUMBER_WARN4:       "WARN4",
+		lpb.SeverityNumber_SEVERITY_NUMBER_ERROR:       "ERROR",
+		lpb.SeverityNumber_SEVERITY_NUMBER_FATAL4:      "FATAL4",
+		lpb.SeverityNumber(25):                         "",
+	}
+	for v, want := range tests {
+		assert.Equalf(t, want, severityText(v), "severity number %d", v)
+	}
+}
+
+func TestFormatterLevelNames(t *testing.T) {
+	f := NewFormatter(Options{LevelNames: map[int]string{0: "INFO", 1: "DEBUG"}})
+	assert.Equal(t, "INFO", f.FormatInfo(0, "message", nil).SeverityText)
+	assert.Equal(t, "DEBUG", f.FormatInfo(1, "message", nil).SeverityText)
+	assert.Equal(t, "WARN2", f.FormatInfo(2, "message", nil).SeverityText)
+	assert.Equal(t, "ERROR", f.FormatError(errors.New("error"), "message", nil).SeverityText)
+}
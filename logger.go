@@ -16,21 +16,32 @@ package otlpr
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync/atomic"
+	"time"
 
 	"github.com/MrAlias/otlpr/internal"
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/sdk/instrumentation"
 	"go.opentelemetry.io/otel/sdk/resource"
-	collpb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
 	cpb "go.opentelemetry.io/proto/otlp/common/v1"
-	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
 	rpb "go.opentelemetry.io/proto/otlp/resource/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
+// defaultExportTimeout is the default value of Options.ExportTimeout.
+const defaultExportTimeout = 10 * time.Second
+
 // New returns a new logr Logger that will export logs over conn using OTLP.
 // The conn is expected to be ready to use when passed. If conn is nil a
 // discard logger is returned.
+//
+// The logger needs to be shut down with Shutdown once it is no longer used.
+// See NewWithExporter for details.
 func New(conn *grpc.ClientConn) logr.Logger {
 	return NewWithOptions(conn, Options{})
 }
@@ -40,6 +51,22 @@ func NewWithOptions(conn *grpc.ClientConn, opts Options) logr.Logger {
 	if conn == nil {
 		return logr.Discard()
 	}
+	return NewWithExporter(newGRPCExporter(conn, opts.Compression), opts)
+}
+
+// NewWithExporter returns a new logr Logger that will export logs with exp.
+// If exp is nil a discard logger is returned.
+//
+// Messages are exported by background goroutines. Call Shutdown once the
+// logger is no longer used to export any queued messages and release these
+// goroutines and exp. If the logger, and all loggers derived from it, are
+// garbage collected without being shut down, they are shut down in the
+// background. That shutdown is given 30 seconds to export the queued
+// messages, and they may be lost if the program exits first.
+func NewWithExporter(exp Exporter, opts Options) logr.Logger {
+	if exp == nil {
+		return logr.Discard()
+	}
 
 	if opts.Depth < 0 {
 		opts.Depth = 0
@@ -48,13 +75,43 @@ func NewWithOptions(conn *grpc.ClientConn, opts Options) logr.Logger {
 	fopts := internal.Options{
 		LogCaller:     internal.MessageClass(opts.LogCaller),
 		LogCallerFunc: opts.LogCallerFunc,
+		LevelNames:    maps.Clone(opts.LevelNames),
+	}
+	if opts.NameFormat == NameAttribute {
+		fopts.NameKey = opts.NameKey
+		if fopts.NameKey == "" {
+			fopts.NameKey = defaultNameKey
+		}
+	}
+
+	if opts.ExportTimeout <= 0 {
+		opts.ExportTimeout = defaultExportTimeout
+	}
+	if opts.ErrorHandler == nil {
+		opts.ErrorHandler = otel.Handle
 	}
 
 	l := &logSink{
-		client:    collpb.NewLogsServiceClient(conn),
-		formatter: internal.NewFormatter(fopts),
+		exporter:   exp,
+		errHandler: opts.ErrorHandler,
+		timeout:    opts.ExportTimeout,
+		retry:      opts.Retry.withDefaults(),
+		headers:    maps.Clone(opts.Headers),
+		headersFn:  opts.HeadersFunc,
+		stats:      new(stats),
+		formatter:  internal.NewFormatter(fopts),
+		nameScope:  opts.NameFormat == NameScope,
+		verbosity:  opts.LevelVar,
 	}
-	l.batcher = opts.Batcher.start(l.export)
+	if l.verbosity == nil {
+		l.verbosity = new(LevelVar)
+		l.verbosity.Set(opts.Verbosity)
+	}
+	l.updateOrigin()
+	// The batcher exports with a copy of l that does not reference it. This
+	// allows the batcher to be shut down once l is unreachable.
+	e := l.clone()
+	l.batcher = newBatcherRef(opts.Batcher.start(e.export, e.shutdown, e.errHandler), cleanupTimeout)
 
 	// For skip our own logSink.Info/Error.
 	l.formatter.AddCallDepth(1 + opts.Depth)
@@ -70,6 +127,24 @@ type Options struct {
 	// be treated as zero.
 	Depth int
 
+	// Verbosity is the maximum V-level of info messages that are logged
+	// (see logr.Logger.V). Info messages with a greater V-level are
+	// discarded without being formatted. Error messages are always logged.
+	// By default, only V(0) info messages are logged.
+	Verbosity int
+
+	// LevelVar, if not nil, is used instead of Verbosity. It is consulted
+	// every time a logger checks if a V-level is enabled, allowing the
+	// verbosity of the logger, and all loggers derived from it, to be changed
+	// while they are in use.
+	LevelVar *LevelVar
+
+	// LevelNames are the severity text of info messages logged at each
+	// V-level (e.g. {0: "INFO", 1: "DEBUG"}). By default, the severity text
+	// is the short name of the severity number the V-level is mapped to
+	// (e.g. "WARN4" for V(0)). Error messages always use "ERROR".
+	LevelNames map[int]string
+
 	// LogCaller tells otlpr to add a "caller" key to some or all log lines.
 	LogCaller MessageClass
 
@@ -80,8 +155,97 @@ type Options struct {
 	// Batcher tells otlpr to batch log messages with the provided Batcher
 	// configuration.
 	Batcher Batcher
+
+	// ErrorHandler is called with any error that occurs while exporting
+	// logs. It needs to be safe for concurrent use.
+	//
+	// If ErrorHandler is nil, errors are passed to the global OpenTelemetry
+	// error handler (see go.opentelemetry.io/otel.Handle).
+	ErrorHandler func(error)
+
+	// ExportTimeout is the maximum amount of time each attempt to export is
+	// allowed to take before it is abandoned. The total time spent retrying
+	// an export is bounded by Retry.MaxElapsedTime.
+	//
+	// If ExportTimeout is less than or equal to zero, the default value of 10
+	// seconds is used.
+	ExportTimeout time.Duration
+
+	// Retry defines how failed exports are retried. By default, failed
+	// exports are not retried.
+	Retry RetryConfig
+
+	// HTTPEncoding is the payload encoding used by loggers exporting with
+	// OTLP/HTTP. It has no effect on loggers exporting with gRPC.
+	HTTPEncoding Encoding
+
+	// Compression is the compression applied to exported payloads by loggers
+	// created with NewWithOptions, NewHTTPWithOptions, or NewFromEndpoint. It
+	// has no effect on loggers using a custom Exporter, pass the compression
+	// to NewGRPCExporter or NewHTTPExporter instead. By default, payloads are
+	// not compressed.
+	Compression Compression
+
+	// Headers are sent with every export. They are added to the context
+	// passed to the Exporter as outgoing gRPC metadata (see
+	// google.golang.org/grpc/metadata), which the OTLP/HTTP exporter sends as
+	// HTTP headers.
+	Headers map[string]string
+
+	// HeadersFunc, if not nil, is called before every export attempt to
+	// return additional headers to send with it. Headers it returns replace
+	// any in Headers with the same name. It is useful for credentials that
+	// are rotated. It is passed the context of the export and needs to be
+	// safe for concurrent use.
+	HeadersFunc func(context.Context) map[string]string
+
+	// Connection configures the gRPC connection dialed by loggers created
+	// with NewFromEndpoint. It has no effect on other loggers.
+	Connection Connection
+
+	// NameFormat defines how the name of a logger (see logr.Logger.WithName)
+	// is exported. By default, it is exported as the NameKey attribute of
+	// each log record.
+	NameFormat NameFormat
+
+	// NameKey is the attribute key used to export the logger name when
+	// NameFormat is NameAttribute. If NameKey is empty, "logger.name" is used.
+	NameKey string
 }
 
+// LevelVar is a verbosity level that can be changed while loggers are using
+// it. It is safe for concurrent use. The zero value is a level of 0.
+type LevelVar struct {
+	v atomic.Int64
+}
+
+// Level returns the verbosity level.
+func (v *LevelVar) Level() int {
+	return int(v.v.Load())
+}
+
+// Set sets the verbosity level to level.
+func (v *LevelVar) Set(level int) {
+	v.v.Store(int64(level))
+}
+
+// defaultNameKey is the default value of Options.NameKey.
+const defaultNameKey = "logger.name"
+
+// NameFormat defines how the name of a logger is exported.
+type NameFormat int
+
+const (
+	// NameAttribute exports the logger name as an attribute of each log
+	// record.
+	NameAttribute NameFormat = iota
+	// NameScope exports the logger name as the instrumentation scope name of
+	// the log records. Log records from named loggers are exported in a
+	// separate ScopeLogs that replaces any scope set with WithScope. Log
+	// records from loggers without a name use the scope set with WithScope.
+	NameScope
+)
+
 // MessageClass indicates which category or categories of messages to consider.
 type MessageClass int
 
@@ -97,17 +261,27 @@ const (
 )
 
 type logSink struct {
-	client  collpb.LogsServiceClient
-	batcher *batcher
+	exporter   Exporter
+	errHandler func(error)
+	timeout    time.Duration
+	retry      RetryConfig
+	headers    map[string]string
+	headersFn  func(context.Context) map[string]string
+	stats      *stats
+	batcher    *batcherRef
 
 	formatter internal.Formatter
-	level     int
+	verbosity *LevelVar
 
 	res       *rpb.Resource
 	resSchema string
 
 	scope       *cpb.InstrumentationScope
 	scopeSchema string
+
+	// nameScope is true if the logger name is exported as the scope name.
+	nameScope bool
+	origin    *origin
 }
 
 var _ logr.LogSink = &logSink{}
@@ -117,57 +291,133 @@ func (l *logSink) Init(ri logr.RuntimeInfo) {
 }
 
 func (l *logSink) Enabled(level int) bool {
-	return level >= l.level
+	return level <= l.verbosity.Level()
 }
 
 func (l *logSink) Info(level int, msg string, keysAndValues ...interface{}) {
-	l.batcher.Append(l.formatter.FormatInfo(level, msg, keysAndValues))
+	l.batcher.Append(record{
+		LogRecord: l.formatter.FormatInfo(level, msg, keysAndValues),
+		origin:    l.origin,
+	})
 }
 
 func (l *logSink) Error(err error, msg string, keysAndValues ...interface{}) {
-	l.batcher.Append(l.formatter.FormatError(err, msg, keysAndValues))
+	l.batcher.Append(record{
+		LogRecord: l.formatter.FormatError(err, msg, keysAndValues),
+		origin:    l.origin,
+	})
+}
+
+// clone returns a copy of l. The copy shares the exporter and batcher of l,
+// but changes to it do not affect l.
+func (l *logSink) clone() *logSink {
+	c := *l
+	return &c
 }
 
 func (l *logSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
-	l.formatter.AddValues(keysAndValues)
-	return l
+	c := l.clone()
+	c.formatter.AddValues(keysAndValues)
+	return c
 }
 
 func (l *logSink) WithName(name string) logr.LogSink {
-	l.formatter.AddName(name)
-	return l
+	c := l.clone()
+	c.formatter.AddName(name)
+	if c.nameScope {
+		c.updateOrigin()
+	}
+	return c
 }
 
 func (l *logSink) WithContext(ctx context.Context) logr.LogSink {
-	l.formatter.AddContext(ctx)
-	return l
+	c := l.clone()
+	c.formatter.AddContext(ctx)
+	return c
 }
 
 func (l *logSink) WithResource(res *resource.Resource) logr.LogSink {
-	l.resSchema, l.res = l.formatter.FormatResource(res)
-	return l
+	c := l.clone()
+	c.resSchema, c.res = c.formatter.FormatResource(res)
+	c.updateOrigin()
+	return c
 }
 
 func (l *logSink) WithScope(s instrumentation.Scope) logr.LogSink {
-	l.scopeSchema, l.scope = l.formatter.FormatScope(s)
-	return l
+	c := l.clone()
+	c.scopeSchema, c.scope = c.formatter.FormatScope(s)
+	c.updateOrigin()
+	return c
 }
 
-func (l *logSink) export(rec []*lpb.LogRecord) {
-	sl := &lpb.ScopeLogs{LogRecords: rec}
-	if l.scope != nil {
-		sl.SchemaUrl, sl.Scope = l.scopeSchema, l.scope
+// updateOrigin sets the origin of the records l creates to match its current
+// resource, scope, and name.
+func (l *logSink) updateOrigin() {
+	o := &origin{
+		res:         l.res,
+		resSchema:   l.resSchema,
+		scope:       l.scope,
+		scopeSchema: l.scopeSchema,
 	}
-
-	rl := &lpb.ResourceLogs{ScopeLogs: []*lpb.ScopeLogs{sl}}
-	if l.res != nil {
-		rl.SchemaUrl, rl.Resource = l.resSchema, l.res
+	if name := l.formatter.Name(); l.nameScope && name != "" {
+		o.scope = &cpb.InstrumentationScope{Name: name}
+		o.scopeSchema = ""
 	}
-	_, _ = l.client.Export(context.Background(), &collpb.ExportLogsServiceRequest{
-		ResourceLogs: []*lpb.ResourceLogs{rl},
+	l.origin = o
+}
+
+// export exports recs and reports any error to the error handler. The error
+// is also returned unless it is a partial success, the records were still
+// delivered.
+func (l *logSink) export(ctx context.Context, recs []record) error {
+	rls := resourceLogs(recs)
+	err := l.retry.do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+		return l.exporter.Export(l.withHeaders(ctx), rls)
 	})
-	// TODO: handle partial success response.
-	// TODO: handle returned error (log it?).
+	if err == nil {
+		return nil
+	}
+
+	var ps *PartialSuccess
+	if errors.As(err, &ps) {
+		// The export was accepted, only some of its records were rejected.
+		l.errHandler(fmt.Errorf("otlpr: log records rejected: %w", err))
+		l.stats.rejected.Add(uint64(ps.RejectedLogRecords))
+		return nil
+	}
+	l.errHandler(fmt.Errorf("otlpr: failed to export logs: %w", err))
+	return err
+}
+
+// withHeaders returns ctx with the export headers added to its outgoing gRPC
+// metadata.
+func (l *logSink) withHeaders(ctx context.Context) context.Context {
+	if len(l.headers) == 0 && l.headersFn == nil {
+		return ctx
+	}
+
+	h := maps.Clone(l.headers)
+	if l.headersFn != nil {
+		if h == nil {
+			h = make(map[string]string)
+		}
+		maps.Copy(h, l.headersFn(ctx))
+	}
+
+	kv := make([]string, 0, 2*len(h))
+	for k, v := range h {
+		kv = append(kv, k, v)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+func (l *logSink) shutdown(ctx context.Context) error {
+	if err := l.exporter.Shutdown(ctx); err != nil {
+		return fmt.Errorf("otlpr: failed to shutdown exporter: %w", err)
+	}
+	return nil
 }
 
 // WithContext returns an updated logger that will log information about any
@@ -199,3 +449,43 @@ func WithScope(l logr.Logger, scope instrumentation.Scope) logr.Logger {
 	}
 	return l
 }
+
+// Flush exports all log records l has buffered. It returns once the export
+// is complete or ctx is done, in which case the context error is returned.
+// Errors from the export itself are passed to the Options.ErrorHandler. It
+// does nothing for loggers where the sink is not from this package.
+//
+// All loggers derived from the same logger share the same buffer, flushing
+// any of them flushes all of them.
+func Flush(ctx context.Context, l logr.Logger) error {
+	ls, ok := l.GetSink().(*logSink)
+	if !ok {
+		return nil
+	}
+	if err := ls.batcher.Flush(ctx); err != nil {
+		return err
+	}
+	if f, ok := ls.exporter.(flusher); ok {
+		return f.ForceFlush(ctx)
+	}
+	return nil
+}
+
+// Shutdown flushes all log records l has buffered and then shuts down its
+// Exporter. Log records recorded after Shutdown is called are dropped. It
+// does nothing for loggers where the sink is not from this package.
+//
+// All loggers derived from the same logger share the same Exporter, shutting
+// down any of them shuts down all of them. Only the first call to Shutdown
+// does this, subsequent calls return nil.
+//
+// If ctx is done before the buffered log records are exported, in-flight
+// exports are canceled and the remaining records are dropped. Once the
+// canceled exports return, the Exporter is still shut down and the context
+// error is returned.
+func Shutdown(ctx context.Context, l logr.Logger) error {
+	if ls, ok := l.GetSink().(*logSink); ok {
+		return ls.batcher.Shutdown(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,457 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testExporter is an in-memory Exporter used for testing.
+type testExporter struct {
+	exports  chan []*lpb.ResourceLogs
+	shutdown chan struct{}
+	err      error
+
+	shutdownOnce sync.Once
+}
+
+func newTestExporter(n int) *testExporter {
+	return &testExporter{
+		exports:  make(chan []*lpb.ResourceLogs, n),
+		shutdown: make(chan struct{}),
+	}
+}
+
+func (e *testExporter) Export(_ context.Context, rl []*lpb.ResourceLogs) error {
+	e.exports <- rl
+	return e.err
+}
+
+func (e *testExporter) Shutdown(context.Context) error {
+	e.shutdownOnce.Do(func() { close(e.shutdown) })
+	return nil
+}
+
+func (e *testExporter) next(t *testing.T) []*lpb.ResourceLogs {
+	t.Helper()
+	select {
+	case rl := <-e.exports:
+		return rl
+	case <-time.After(3 * time.Second):
+		require.Fail(t, "missing export")
+	}
+	return nil
+}
+
+func TestNewWithExporterNil(t *testing.T) {
+	assert.Equal(t, logr.Discard(), NewWithExporter(nil, Options{}))
+}
+
+func TestNewWithExporter(t *testing.T) {
+	exp := newTestExporter(1)
+	l := NewWithExporter(exp, Options{Batcher: Batcher{Messages: 1}})
+
+	res := resource.NewWithAttributes("", attribute.String("service.name", "test"))
+	l = WithResource(l, res)
+	l = WithScope(l, instrumentation.Scope{Name: "scope", Version: "v0.1.0"})
+	l.Info("message")
+
+	rl := exp.next(t)
+	require.Len(t, rl, 1)
+	require.Len(t, rl[0].Resource.Attributes, 1)
+	assert.Equal(t, "service.name", rl[0].Resource.Attributes[0].Key)
+
+	require.Len(t, rl[0].ScopeLogs, 1)
+	sl := rl[0].ScopeLogs[0]
+	assert.Equal(t, "scope", sl.Scope.Name)
+	assert.Equal(t, "v0.1.0", sl.Scope.Version)
+	require.Len(t, sl.LogRecords, 1)
+	assert.Equal(t, "message", sl.LogRecords[0].Body.GetStringValue())
+}
+
+func TestErrorHandler(t *testing.T) {
+	exp := newTestExporter(1)
+	exp.err = errors.New("export failure")
+
+	errs := make(chan error, 1)
+	l := NewWithExporter(exp, Options{
+		Batcher:      Batcher{Messages: 1},
+		ErrorHandler: func(err error) { errs <- err },
+	})
+	l.Info("message")
+	exp.next(t)
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, exp.err)
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "error not handled")
+	}
+}
+
+func TestPartialSuccessStats(t *testing.T) {
+	exp := newTestExporter(1)
+	exp.err = &PartialSuccess{RejectedLogRecords: 2, ErrorMessage: "rejected"}
+
+	errs := make(chan error, 1)
+	l := NewWithExporter(exp, Options{
+		Batcher:      Batcher{Messages: 1},
+		ErrorHandler: func(err error) { errs <- err },
+	})
+	assert.Equal(t, Stats{}, Statistics(l))
+
+	l.Info("message")
+	exp.next(t)
+
+	select {
+	case err := <-errs:
+		var ps *PartialSuccess
+		assert.ErrorAs(t, err, &ps)
+		assert.NotContains(t, err.Error(), "failed to export")
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "error not handled")
+	}
+	assert.Equal(t, Stats{RejectedLogRecords: 2}, Statistics(l))
+	assert.Equal(t, Stats{}, Statistics(logr.Discard()))
+}
+
+// blockingExporter is an Exporter whose exports block until canceled.
+type blockingExporter struct{}
+
+func (blockingExporter) Export(ctx context.Context, _ []*lpb.ResourceLogs) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingExporter) Shutdown(context.Context) error { return nil }
+
+func TestExportTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	l := NewWithExporter(blockingExporter{}, Options{
+		Batcher:       Batcher{Messages: 1},
+		ExportTimeout: time.Millisecond,
+		ErrorHandler:  func(err error) { errs <- err },
+	})
+
+	done := make(chan struct{})
+	go func() {
+		l.Info("message")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		require.Fail(t, "export did not time out")
+	}
+	assert.ErrorIs(t, <-errs, context.DeadlineExceeded)
+}
+
+// slowExporter is an Exporter whose first n exports block until canceled.
+type slowExporter struct {
+	n       atomic.Int64
+	success chan struct{}
+}
+
+func (e *slowExporter) Export(ctx context.Context, _ []*lpb.ResourceLogs) error {
+	if e.n.Add(-1) >= 0 {
+		<-ctx.Done()
+		return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+	}
+	close(e.success)
+	return nil
+}
+
+func (*slowExporter) Shutdown(context.Context) error { return nil }
+
+func TestExportTimeoutPerAttempt(t *testing.T) {
+	exp := &slowExporter{success: make(chan struct{})}
+	exp.n.Store(2)
+
+	errs := make(chan error, 1)
+	l := NewWithExporter(exp, Options{
+		Batcher:       Batcher{Messages: 1},
+		ExportTimeout: 50 * time.Millisecond,
+		Retry: RetryConfig{
+			Enabled:         true,
+			InitialInterval: time.Millisecond,
+			MaxInterval:     time.Millisecond,
+			MaxElapsedTime:  time.Minute,
+		},
+		ErrorHandler: func(err error) { errs <- err },
+	})
+	l.Info("message")
+
+	select {
+	case <-exp.success:
+	case err := <-errs:
+		require.NoError(t, err, "retries bounded by export timeout")
+	case <-time.After(3 * time.Second):
+		require.Fail(t, "export not retried")
+	}
+}
+
+func TestFlushAndShutdown(t *testing.T) {
+	exp := newTestExporter(1)
+	l := NewWithExporter(exp, Options{})
+
+	l.Info("message")
+	require.NoError(t, Flush(context.Background(), l))
+	rl := exp.next(t)
+	assert.Len(t, rl[0].ScopeLogs[0].LogRecords, 1)
+
+	l.Info("message")
+	require.NoError(t, Shutdown(context.Background(), l))
+	rl = exp.next(t)
+	assert.Len(t, rl[0].ScopeLogs[0].LogRecords, 1)
+
+	select {
+	case <-exp.shutdown:
+	default:
+		assert.Fail(t, "exporter not shut down")
+	}
+
+	assert.NoError(t, Flush(context.Background(), logr.Discard()))
+	assert.NoError(t, Shutdown(context.Background(), logr.Discard()))
+}
+
+func TestUnreachableLoggerShutdown(t *testing.T) {
+	exp := newTestExporter(1)
+	func() {
+		l := NewWithExporter(exp, Options{Batcher: Batcher{Timeout: time.Hour}})
+		l.WithName("derived").Info("message")
+	}()
+
+	deadline := time.After(3 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case <-exp.shutdown:
+			assert.Len(t, exp.next(t), 1, "queued messages not exported")
+			return
+		case <-deadline:
+			require.Fail(t, "unreachable logger not shut down")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// headersExporter is an Exporter that records the outgoing gRPC metadata of
+// each export.
+type headersExporter struct {
+	blockingExporter
+	md chan metadata.MD
+}
+
+func (e headersExporter) Export(ctx context.Context, _ []*lpb.ResourceLogs) error {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	e.md <- md
+	return nil
+}
+
+func TestHeaders(t *testing.T) {
+	exp := headersExporter{md: make(chan metadata.MD, 1)}
+	key := "key-1"
+	l := NewWithExporter(exp, Options{
+		Batcher: Batcher{Messages: 1},
+		Headers: map[string]string{
+			"X-Scope-OrgID": "tenant",
+			"Authorization": "static",
+		},
+		HeadersFunc: func(context.Context) map[string]string {
+			return map[string]string{"Authorization": key}
+		},
+	})
+
+	l.Info("message")
+	md := <-exp.md
+	assert.Equal(t, []string{"tenant"}, md.Get("X-Scope-OrgID"))
+	assert.Equal(t, []string{"key-1"}, md.Get("Authorization"))
+
+	require.NoError(t, Flush(context.Background(), l))
+	key = "key-2"
+	l.Info("message")
+	md = <-exp.md
+	assert.Equal(t, []string{"key-2"}, md.Get("Authorization"))
+}
+
+func TestNameAttribute(t *testing.T) {
+	exp := newTestExporter(1)
+	l := NewWithExporter(exp, Options{Batcher: Batcher{Messages: 1}})
+	l.WithName("controller").Info("message")
+
+	lr := exp.next(t)[0].ScopeLogs[0].LogRecords[0]
+	require.Len(t, lr.Attributes, 1)
+	assert.Equal(t, "logger.name", lr.Attributes[0].Key)
+	assert.Equal(t, "controller", lr.Attributes[0].Value.GetStringValue())
+}
+
+func TestNameScope(t *testing.T) {
+	exp := newTestExporter(1)
+	l := NewWithExporter(exp, Options{
+		Batcher:    Batcher{Messages: 2},
+		NameFormat: NameScope,
+	})
+	l = WithScope(l, instrumentation.Scope{Name: "scope", Version: "v0.1.0"})
+	l.Info("unnamed")
+	l.WithName("controller").Info("named")
+
+	rl := exp.next(t)
+	require.Len(t, rl, 1)
+	require.Len(t, rl[0].ScopeLogs, 2)
+
+	sl := rl[0].ScopeLogs[0]
+	assert.Equal(t, "scope", sl.Scope.Name)
+	require.Len(t, sl.LogRecords, 1)
+	assert.Equal(t, "unnamed", sl.LogRecords[0].Body.GetStringValue())
+
+	sl = rl[0].ScopeLogs[1]
+	assert.Equal(t, "controller", sl.Scope.Name)
+	require.Len(t, sl.LogRecords, 1)
+	assert.Equal(t, "named", sl.LogRecords[0].Body.GetStringValue())
+	assert.Empty(t, sl.LogRecords[0].Attributes)
+}
+
+func TestVerbosity(t *testing.T) {
+	exp := newTestExporter(1)
+	l := NewWithExporter(exp, Options{Verbosity: 2})
+	assert.True(t, l.V(0).Enabled())
+	assert.True(t, l.V(2).Enabled())
+	assert.False(t, l.V(3).Enabled())
+
+	l.V(3).Info("debug")
+	l.V(2).Info("info")
+	l.V(3).Error(errors.New("error"), "error")
+	require.NoError(t, Flush(context.Background(), l))
+
+	lrs := exp.next(t)[0].ScopeLogs[0].LogRecords
+	require.Len(t, lrs, 2)
+	assert.Equal(t, "info", lrs[0].Body.GetStringValue())
+
+	l = NewWithExporter(exp, Options{})
+	assert.True(t, l.V(0).Enabled())
+	assert.False(t, l.V(1).Enabled())
+}
+
+func TestLevelVar(t *testing.T) {
+	v := new(LevelVar)
+	l := NewWithExporter(newTestExporter(0), Options{Verbosity: 5, LevelVar: v})
+	child := l.WithName("child").WithValues("key", "value")
+	assert.True(t, l.V(0).Enabled())
+	assert.False(t, child.V(1).Enabled())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = child.V(3).Enabled()
+		}
+	}()
+	v.Set(3)
+	<-done
+
+	assert.Equal(t, 3, v.Level())
+	assert.True(t, l.V(3).Enabled())
+	assert.True(t, child.V(3).Enabled())
+	assert.False(t, child.V(4).Enabled())
+}
+
+func TestDerivedLoggersDoNotModifyParent(t *testing.T) {
+	exp := newTestExporter(1)
+	l := NewWithExporter(exp, Options{Batcher: Batcher{Messages: 1}})
+	l = WithScope(l, instrumentation.Scope{Name: "parent"})
+
+	child := l.WithValues("req", 1).WithName("child")
+	child = WithScope(child, instrumentation.Scope{Name: "child"})
+	child = WithResource(child, resource.NewWithAttributes("", attribute.String("service.name", "child")))
+	child = WithContext(child, context.Background())
+	_ = child
+
+	l.Info("message")
+	rl := exp.next(t)
+	require.Len(t, rl, 1)
+	assert.Nil(t, rl[0].Resource)
+	require.Len(t, rl[0].ScopeLogs, 1)
+	assert.Equal(t, "parent", rl[0].ScopeLogs[0].Scope.Name)
+	assert.Empty(t, rl[0].ScopeLogs[0].LogRecords[0].Attributes)
+}
+
+func TestConcurrentDerivation(t *testing.T) {
+	const n = 50
+	exp := newTestExporter(1)
+	l := NewWithExporter(exp, Options{Batcher: Batcher{Messages: n}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			child := l.WithValues("id", i).WithName("worker")
+			child = WithScope(child, instrumentation.Scope{Name: "scope"})
+			child = WithContext(child, context.Background())
+			child.Info("message")
+		}()
+	}
+	wg.Wait()
+
+	rl := exp.next(t)
+	require.Len(t, rl, 1)
+	require.Len(t, rl[0].ScopeLogs, 1)
+	lrs := rl[0].ScopeLogs[0].LogRecords
+	require.Len(t, lrs, n)
+
+	ids := make(map[int64]bool)
+	for _, lr := range lrs {
+		require.Len(t, lr.Attributes, 2, "values from other loggers included")
+		assert.Equal(t, "id", lr.Attributes[0].Key)
+		ids[lr.Attributes[0].Value.GetIntValue()] = true
+	}
+	assert.Len(t, ids, n)
+}
+
+func TestSeverityText(t *testing.T) {
+	exp := newTestExporter(1)
+	l := NewWithExporter(exp, Options{
+		Batcher:    Batcher{Messages: 3},
+		Verbosity:  1,
+		LevelNames: map[int]string{1: "DEBUG"},
+	})
+	l.Info("info")
+	l.V(1).Info("debug")
+	l.Error(errors.New("error"), "error")
+
+	lrs := exp.next(t)[0].ScopeLogs[0].LogRecords
+	require.Len(t, lrs, 3)
+	assert.Equal(t, "WARN4", lrs[0].SeverityText)
+	assert.Equal(t, "DEBUG", lrs[1].SeverityText)
+	assert.Equal(t, "ERROR", lrs[2].SeverityText)
+}
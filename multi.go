@@ -0,0 +1,246 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Destination is an Exporter and the configuration used to export to it
+// from a multi-destination Exporter (see NewMultiExporter).
+type Destination struct {
+	// Exporter exports the log data sent to this destination.
+	Exporter Exporter
+
+	// ExportTimeout is the maximum amount of time each attempt to export to
+	// this destination is allowed to take. The total time spent retrying an
+	// export is bounded by Retry.MaxElapsedTime.
+	//
+	// If ExportTimeout is less than or equal to zero, the default value of 10
+	// seconds is used.
+	ExportTimeout time.Duration
+
+	// Retry defines how failed exports to this destination are retried. By
+	// default, failed exports are not retried.
+	Retry RetryConfig
+
+	// ErrorHandler is called with any error that occurs while exporting to
+	// this destination. It needs to be safe for concurrent use.
+	//
+	// Partial successes (see PartialSuccess) are also reported to it. They
+	// are not counted in the Stats of the logger using the multi-destination
+	// Exporter.
+	//
+	// If ErrorHandler is nil, errors are passed to the global OpenTelemetry
+	// error handler (see go.opentelemetry.io/otel.Handle).
+	ErrorHandler func(error)
+
+	// MaxQueueSize is the maximum number of exports waiting to be sent to
+	// this destination. Once this many are waiting, new exports to this
+	// destination are dropped and reported to the ErrorHandler.
+	//
+	// If MaxQueueSize is less than or equal to zero, the default value of 8
+	// is used.
+	MaxQueueSize int
+}
+
+// NewMultiExporter returns an Exporter that exports the same log data to all
+// dests. Destinations with a nil Exporter are ignored.
+//
+// Each destination is exported to independently by its own goroutine. A slow
+// or failing destination does not delay exports to the others. Because of
+// this, Export returns before the log data is delivered and it does not
+// return any errors, they are reported to the ErrorHandler of the
+// destination.
+//
+// The returned Exporter also implements a ForceFlush method that waits for
+// all pending exports to be delivered. It is called by Flush.
+func NewMultiExporter(dests ...Destination) Exporter {
+	m := &multiExporter{}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+
+	for _, d := range dests {
+		if d.Exporter == nil {
+			continue
+		}
+		if d.ExportTimeout <= 0 {
+			d.ExportTimeout = defaultExportTimeout
+		}
+		d.Retry = d.Retry.withDefaults()
+		if d.ErrorHandler == nil {
+			d.ErrorHandler = otel.Handle
+		}
+		if d.MaxQueueSize <= 0 {
+			d.MaxQueueSize = defaultMaxQueueSize
+		}
+
+		dest := &destination{
+			Destination: d,
+			queue:       make(chan exportRequest, d.MaxQueueSize),
+			pending:     newPending(),
+		}
+		m.dests = append(m.dests, dest)
+
+		m.wg.Add(1)
+		go m.run(dest)
+	}
+	return m
+}
+
+type multiExporter struct {
+	dests []*destination
+
+	// mu guards sending on, and closing, the destination queues.
+	mu     sync.RWMutex
+	closed bool
+
+	// ctx is canceled if a shutdown cannot wait for pending exports.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type destination struct {
+	Destination
+
+	queue   chan exportRequest
+	pending *pending
+}
+
+type exportRequest struct {
+	ctx context.Context
+	rl  []*lpb.ResourceLogs
+}
+
+var _ Exporter = (*multiExporter)(nil)
+
+func (m *multiExporter) Export(ctx context.Context, rl []*lpb.ResourceLogs) error {
+	// The log data is exported after Export returns, copy it so the caller
+	// is free to reuse it.
+	cp := make([]*lpb.ResourceLogs, len(rl))
+	for i, r := range rl {
+		cp[i] = proto.Clone(r).(*lpb.ResourceLogs)
+	}
+	// Keep context values (e.g. gRPC metadata), but not the cancellation.
+	req := exportRequest{ctx: context.WithoutCancel(ctx), rl: cp}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return errors.New("otlpr: multi-exporter is shut down")
+	}
+
+	for _, d := range m.dests {
+		d.pending.Add(1)
+		select {
+		case d.queue <- req:
+		default:
+			d.pending.Add(-1)
+			d.ErrorHandler(errors.New("otlpr: destination queue is full, dropping export"))
+		}
+	}
+	return nil
+}
+
+// run exports the log data sent to d until its queue is closed. Log data
+// still queued once the multiExporter is canceled is dropped.
+func (m *multiExporter) run(d *destination) {
+	defer m.wg.Done()
+	for req := range d.queue {
+		if m.ctx.Err() != nil {
+			d.pending.Add(-1)
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(req.ctx)
+		stop := context.AfterFunc(m.ctx, cancel)
+
+		err := d.Retry.do(ctx, func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, d.ExportTimeout)
+			defer cancel()
+			return d.Exporter.Export(ctx, req.rl)
+		})
+		var ps *PartialSuccess
+		switch {
+		case err == nil:
+		case errors.As(err, &ps):
+			d.ErrorHandler(fmt.Errorf("otlpr: log records rejected: %w", err))
+		default:
+			d.ErrorHandler(fmt.Errorf("otlpr: failed to export logs: %w", err))
+		}
+
+		stop()
+		cancel()
+		d.pending.Add(-1)
+	}
+}
+
+// ForceFlush waits for all pending exports to be delivered to all
+// destinations, or ctx to be done.
+func (m *multiExporter) ForceFlush(ctx context.Context) error {
+	for _, d := range m.dests {
+		if err := d.pending.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown waits for all pending exports to be delivered and then shuts down
+// the Exporter of each destination. If ctx is done before the pending exports
+// are delivered, they are canceled, and once the canceled exports return the
+// Exporters are still shut down.
+func (m *multiExporter) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	for _, d := range m.dests {
+		close(d.queue)
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	var errs []error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, ctx.Err())
+	}
+	// Wait for the canceled exports to return so nothing is exported after
+	// the Exporters are shut down.
+	m.cancel()
+	<-done
+
+	for _, d := range m.dests {
+		errs = append(errs, d.Exporter.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,210 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+func TestMultiExporter(t *testing.T) {
+	a, b := newTestExporter(1), newTestExporter(1)
+	exp := NewMultiExporter(
+		Destination{Exporter: a},
+		Destination{Exporter: nil},
+		Destination{Exporter: b},
+	)
+
+	rl := []*lpb.ResourceLogs{{SchemaUrl: "test"}}
+	require.NoError(t, exp.Export(context.Background(), rl))
+	assert.Equal(t, "test", a.next(t)[0].SchemaUrl)
+	assert.Equal(t, "test", b.next(t)[0].SchemaUrl)
+
+	require.NoError(t, exp.Shutdown(context.Background()))
+	<-a.shutdown
+	<-b.shutdown
+
+	assert.Error(t, exp.Export(context.Background(), rl))
+}
+
+// startedExporter is a blockingExporter that signals when an export starts.
+type startedExporter struct {
+	blockingExporter
+	started chan struct{}
+}
+
+func (e startedExporter) Export(ctx context.Context, rl []*lpb.ResourceLogs) error {
+	e.started <- struct{}{}
+	return e.blockingExporter.Export(ctx, rl)
+}
+
+func TestMultiExporterIndependentDestinations(t *testing.T) {
+	fast := newTestExporter(2)
+	slow := startedExporter{started: make(chan struct{}, 1)}
+	fastErrs := make(chan error, 1)
+	slowErrs := make(chan error, 2)
+	exp := NewMultiExporter(
+		Destination{
+			Exporter:      slow,
+			ExportTimeout: time.Hour,
+			ErrorHandler:  func(err error) { slowErrs <- err },
+			MaxQueueSize:  1,
+		},
+		Destination{
+			Exporter:     fast,
+			ErrorHandler: func(err error) { fastErrs <- err },
+		},
+	)
+
+	rl := []*lpb.ResourceLogs{{}}
+	require.NoError(t, exp.Export(context.Background(), rl))
+	<-slow.started
+	require.NoError(t, exp.Export(context.Background(), rl))
+	fast.next(t)
+	fast.next(t)
+
+	// The slow destination is blocked on the first export, has the second
+	// queued, and drops the third.
+	require.NoError(t, exp.Export(context.Background(), rl))
+	fast.next(t)
+	assert.ErrorContains(t, <-slowErrs, "queue is full")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, exp.Shutdown(ctx), context.DeadlineExceeded)
+	assert.ErrorIs(t, <-slowErrs, context.Canceled)
+	// Destinations are shut down even if their exports were canceled.
+	<-fast.shutdown
+
+	select {
+	case err := <-fastErrs:
+		assert.Fail(t, "unexpected error", err)
+	default:
+	}
+}
+
+// shutdownExporter is a startedExporter that counts the exports made after
+// it is shut down.
+type shutdownExporter struct {
+	startedExporter
+	shutdown, late atomic.Int64
+}
+
+func (e *shutdownExporter) Export(ctx context.Context, rl []*lpb.ResourceLogs) error {
+	if e.shutdown.Load() > 0 {
+		e.late.Add(1)
+	}
+	return e.startedExporter.Export(ctx, rl)
+}
+
+func (e *shutdownExporter) Shutdown(context.Context) error {
+	e.shutdown.Add(1)
+	return nil
+}
+
+func TestMultiExporterShutdownDropsQueued(t *testing.T) {
+	exp := &shutdownExporter{
+		startedExporter: startedExporter{started: make(chan struct{}, 5)},
+	}
+	m := NewMultiExporter(Destination{
+		Exporter:     exp,
+		ErrorHandler: func(error) {},
+		MaxQueueSize: 5,
+	})
+
+	rl := []*lpb.ResourceLogs{{}}
+	for range 5 {
+		require.NoError(t, m.Export(context.Background(), rl))
+	}
+	<-exp.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, m.Shutdown(ctx), context.DeadlineExceeded)
+	m.(*multiExporter).wg.Wait()
+	assert.Equal(t, int64(1), exp.shutdown.Load())
+	assert.Equal(t, int64(0), exp.late.Load(), "exported after shutdown")
+	assert.Empty(t, exp.started, "queued exports not dropped")
+}
+
+func TestMultiExporterForceFlush(t *testing.T) {
+	a := newTestExporter(1)
+	a.err = errors.New("export failure")
+	errs := make(chan error, 1)
+	exp := NewMultiExporter(Destination{
+		Exporter:     a,
+		ErrorHandler: func(err error) { errs <- err },
+	})
+	t.Cleanup(func() { _ = exp.Shutdown(context.Background()) })
+
+	require.NoError(t, exp.Export(context.Background(), []*lpb.ResourceLogs{{}}))
+	require.NoError(t, exp.(flusher).ForceFlush(context.Background()))
+	a.next(t)
+	assert.ErrorIs(t, <-errs, a.err)
+}
+
+func TestMultiExporterExportTimeoutPerAttempt(t *testing.T) {
+	slow := &slowExporter{success: make(chan struct{})}
+	slow.n.Store(2)
+	errs := make(chan error, 1)
+	exp := NewMultiExporter(Destination{
+		Exporter:      slow,
+		ExportTimeout: 50 * time.Millisecond,
+		Retry: RetryConfig{
+			Enabled:         true,
+			InitialInterval: time.Millisecond,
+			MaxInterval:     time.Millisecond,
+			MaxElapsedTime:  time.Minute,
+		},
+		ErrorHandler: func(err error) { errs <- err },
+	})
+	t.Cleanup(func() { _ = exp.Shutdown(context.Background()) })
+
+	require.NoError(t, exp.Export(context.Background(), []*lpb.ResourceLogs{{}}))
+	select {
+	case <-slow.success:
+	case err := <-errs:
+		require.NoError(t, err, "retries bounded by export timeout")
+	case <-time.After(3 * time.Second):
+		require.Fail(t, "export not retried")
+	}
+}
+
+func TestMultiExporterPartialSuccess(t *testing.T) {
+	a := newTestExporter(1)
+	a.err = &PartialSuccess{RejectedLogRecords: 1, ErrorMessage: "rejected"}
+	errs := make(chan error, 1)
+	exp := NewMultiExporter(Destination{
+		Exporter:     a,
+		ErrorHandler: func(err error) { errs <- err },
+	})
+	t.Cleanup(func() { _ = exp.Shutdown(context.Background()) })
+
+	require.NoError(t, exp.Export(context.Background(), []*lpb.ResourceLogs{{}}))
+	require.NoError(t, exp.(flusher).ForceFlush(context.Background()))
+	a.next(t)
+
+	err := <-errs
+	var ps *PartialSuccess
+	assert.ErrorAs(t, err, &ps)
+	assert.NotContains(t, err.Error(), "failed to export")
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	cpb "go.opentelemetry.io/proto/otlp/common/v1"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	rpb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// record is a log record and the origin of the logger that recorded it.
+type record struct {
+	*lpb.LogRecord
+
+	// origin is shared by all records from the same logger. It is never
+	// nil.
+	origin *origin
+}
+
+// origin is the resource and instrumentation scope of a logger.
+type origin struct {
+	res       *rpb.Resource
+	resSchema string
+
+	scope       *cpb.InstrumentationScope
+	scopeSchema string
+}
+
+type resourceKey struct {
+	res    *rpb.Resource
+	schema string
+}
+
+type scopeKey struct {
+	name, version, schema string
+}
+
+// resourceLogs returns recs grouped by resource and then by instrumentation
+// scope. The order of recs is preserved within each group.
+func resourceLogs(recs []record) []*lpb.ResourceLogs {
+	var out []*lpb.ResourceLogs
+	resIdx := make(map[resourceKey]int)
+	scopeIdx := make(map[resourceKey]map[scopeKey]int)
+	for _, r := range recs {
+		o := r.origin
+		rk := resourceKey{res: o.res, schema: o.resSchema}
+		i, ok := resIdx[rk]
+		if !ok {
+			i = len(out)
+			resIdx[rk] = i
+			scopeIdx[rk] = make(map[scopeKey]int)
+			out = append(out, &lpb.ResourceLogs{
+				Resource:  o.res,
+				SchemaUrl: o.resSchema,
+			})
+		}
+		rl := out[i]
+
+		sk := scopeKey{
+			name:    o.scope.GetName(),
+			version: o.scope.GetVersion(),
+			schema:  o.scopeSchema,
+		}
+		j, ok := scopeIdx[rk][sk]
+		if !ok {
+			j = len(rl.ScopeLogs)
+			scopeIdx[rk][sk] = j
+			rl.ScopeLogs = append(rl.ScopeLogs, &lpb.ScopeLogs{
+				Scope:     o.scope,
+				SchemaUrl: o.scopeSchema,
+			})
+		}
+		sl := rl.ScopeLogs[j]
+		sl.LogRecords = append(sl.LogRecords, r.LogRecord)
+	}
+	return out
+}
+
+// records returns the records contained in rls. It is the inverse of
+// resourceLogs.
+func records(rls []*lpb.ResourceLogs) []record {
+	var out []record
+	for _, rl := range rls {
+		for _, sl := range rl.GetScopeLogs() {
+			o := &origin{
+				res:         rl.GetResource(),
+				resSchema:   rl.GetSchemaUrl(),
+				scope:       sl.GetScope(),
+				scopeSchema: sl.GetSchemaUrl(),
+			}
+			for _, lr := range sl.GetLogRecords() {
+				out = append(out, record{LogRecord: lr, origin: o})
+			}
+		}
+	}
+	return out
+}
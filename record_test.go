@@ -0,0 +1,62 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cpb "go.opentelemetry.io/proto/otlp/common/v1"
+	lpb "go.opentelemetry.io/proto/otlp/logs/v1"
+	rpb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func TestResourceLogs(t *testing.T) {
+	res := &rpb.Resource{}
+	a := &origin{res: res, scope: &cpb.InstrumentationScope{Name: "a"}}
+	// Equal scopes from different loggers are grouped together.
+	a2 := &origin{res: res, scope: &cpb.InstrumentationScope{Name: "a"}}
+	b := &origin{res: res, scope: &cpb.InstrumentationScope{Name: "b"}}
+	none := new(origin)
+
+	rec := func(ts uint64, o *origin) record {
+		return record{LogRecord: &lpb.LogRecord{TimeUnixNano: ts}, origin: o}
+	}
+	recs := []record{rec(1, a), rec(2, none), rec(3, b), rec(4, a2), rec(5, none)}
+
+	rls := resourceLogs(recs)
+	require.Len(t, rls, 2)
+
+	assert.Same(t, res, rls[0].Resource)
+	require.Len(t, rls[0].ScopeLogs, 2)
+	assert.Equal(t, "a", rls[0].ScopeLogs[0].Scope.Name)
+	assert.Equal(t, []uint64{1, 4}, timestamps(newRecordsFrom(rls[0].ScopeLogs[0])))
+	assert.Equal(t, "b", rls[0].ScopeLogs[1].Scope.Name)
+	assert.Equal(t, []uint64{3}, timestamps(newRecordsFrom(rls[0].ScopeLogs[1])))
+
+	assert.Nil(t, rls[1].Resource)
+	require.Len(t, rls[1].ScopeLogs, 1)
+	assert.Nil(t, rls[1].ScopeLogs[0].Scope)
+	assert.Equal(t, []uint64{2, 5}, timestamps(newRecordsFrom(rls[1].ScopeLogs[0])))
+
+	got := records(rls)
+	assert.Equal(t, []uint64{1, 4, 3, 2, 5}, timestamps(got))
+	assert.Equal(t, rls, resourceLogs(got))
+}
+
+func newRecordsFrom(sl *lpb.ScopeLogs) []record {
+	return records([]*lpb.ResourceLogs{{ScopeLogs: []*lpb.ScopeLogs{sl}}})
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults for RetryConfig.
+const (
+	defaultInitialInterval = 5 * time.Second
+	defaultMaxInterval     = 30 * time.Second
+	defaultMaxElapsedTime  = time.Minute
+	defaultJitter          = 0.5
+)
+
+// RetryConfig defines how failed exports are retried.
+//
+// Only failures the OTLP specification defines as retryable are retried.
+// Throttling information returned by the receiving endpoint (gRPC RetryInfo
+// details or the HTTP Retry-After header) is honored.
+type RetryConfig struct {
+	// Enabled determines if failed exports are retried.
+	Enabled bool
+	// InitialInterval is the time to wait after the first failure before
+	// retrying. Subsequent waits are doubled until MaxInterval is reached.
+	//
+	// If InitialInterval is less than or equal to zero, the default value of
+	// 5 seconds is used.
+	InitialInterval time.Duration
+	// MaxInterval is the upper bound on the time to wait between retries.
+	//
+	// If MaxInterval is less than or equal to zero, the default value of 30
+	// seconds is used.
+	MaxInterval time.Duration
+	// MaxElapsedTime is the maximum amount of time spent trying to send a
+	// single export, including all attempts and the waits between them. Once
+	// this much time has elapsed the export is abandoned.
+	//
+	// If MaxElapsedTime is less than or equal to zero, the default value of 1
+	// minute is used.
+	MaxElapsedTime time.Duration
+	// Jitter is the fraction of each wait interval that is randomly added or
+	// subtracted from it (e.g. 0.5 results in waits between 0.5x and 1.5x of
+	// the interval).
+	//
+	// If Jitter is zero, the default value of 0.5 is used. Negative values
+	// disable jitter.
+	Jitter float64
+}
+
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.InitialInterval <= 0 {
+		c.InitialInterval = defaultInitialInterval
+	}
+	if c.MaxInterval <= 0 {
+		c.MaxInterval = defaultMaxInterval
+	}
+	if c.MaxElapsedTime <= 0 {
+		c.MaxElapsedTime = defaultMaxElapsedTime
+	}
+	if c.Jitter == 0 {
+		c.Jitter = defaultJitter
+	} else if c.Jitter < 0 {
+		c.Jitter = 0
+	}
+	return c
+}
+
+// do calls fn until it succeeds, returns an error that cannot be retried,
+// ctx is done, or the MaxElapsedTime is reached.
+func (c RetryConfig) do(ctx context.Context, fn func(context.Context) error) error {
+	if !c.Enabled {
+		return fn(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.MaxElapsedTime)
+	defer cancel()
+
+	start := time.Now()
+	interval := c.InitialInterval
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		ok, throttle := retryable(err)
+		if !ok {
+			return err
+		}
+
+		delay := c.jitter(interval)
+		if throttle > delay {
+			delay = throttle
+		}
+		if time.Since(start)+delay > c.MaxElapsedTime {
+			return fmt.Errorf("max retry time elapsed: %w", err)
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-t.C:
+		}
+
+		if interval *= 2; interval > c.MaxInterval {
+			interval = c.MaxInterval
+		}
+	}
+}
+
+func (c RetryConfig) jitter(d time.Duration) time.Duration {
+	if c.Jitter == 0 {
+		return d
+	}
+	delta := c.Jitter * float64(d)
+	// Uniformly distributed in [d-delta, d+delta].
+	return time.Duration(float64(d) - delta + rand.Float64()*2*delta) //nolint:gosec // Jitter does not need to be secure.
+}
+
+// retryableError is an error that knows if the failed export can be retried.
+type retryableError interface {
+	error
+	// Retryable returns if the export can be retried and the minimum amount
+	// of time the receiving endpoint requested to wait before doing so.
+	Retryable() (bool, time.Duration)
+}
+
+// retryable returns if err is from an export that can be retried and the
+// minimum amount of time the receiving endpoint requested to wait before
+// doing so.
+func retryable(err error) (bool, time.Duration) {
+	var rErr retryableError
+	if errors.As(err, &rErr) {
+		return rErr.Retryable()
+	}
+
+	s, ok := status.FromError(err)
+	if !ok {
+		return false, 0
+	}
+
+	var throttle time.Duration
+	var hasRetryInfo bool
+	for _, d := range s.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			throttle, hasRetryInfo = ri.GetRetryDelay().AsDuration(), true
+		}
+	}
+
+	switch s.Code() {
+	case codes.Canceled,
+		codes.DeadlineExceeded,
+		codes.Aborted,
+		codes.OutOfRange,
+		codes.Unavailable,
+		codes.DataLoss:
+		return true, throttle
+	case codes.ResourceExhausted:
+		// Only retry if the server signals recovery is possible.
+		return hasRetryInfo, throttle
+	}
+	return false, 0
+}
@@ -0,0 +1,174 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRetryable(t *testing.T) {
+	for _, c := range []codes.Code{
+		codes.Canceled,
+		codes.DeadlineExceeded,
+		codes.Aborted,
+		codes.OutOfRange,
+		codes.Unavailable,
+		codes.DataLoss,
+	} {
+		ok, _ := retryable(status.Error(c, ""))
+		assert.Truef(t, ok, "code %s", c)
+	}
+
+	for _, c := range []codes.Code{
+		codes.Unknown,
+		codes.InvalidArgument,
+		codes.NotFound,
+		codes.PermissionDenied,
+		codes.Unauthenticated,
+		codes.ResourceExhausted,
+	} {
+		ok, _ := retryable(status.Error(c, ""))
+		assert.Falsef(t, ok, "code %s", c)
+	}
+
+	ok, _ := retryable(errors.New("unknown"))
+	assert.False(t, ok)
+
+	ok, _ = retryable(&PartialSuccess{RejectedLogRecords: 1})
+	assert.False(t, ok)
+
+	ok, d := retryable(&httpError{err: errors.New("busy"), retry: true, throttle: time.Second})
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, d)
+}
+
+func TestRetryableRetryInfo(t *testing.T) {
+	s, err := status.New(codes.ResourceExhausted, "throttled").WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Minute)},
+	)
+	require.NoError(t, err)
+
+	ok, d := retryable(s.Err())
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+}
+
+func TestRetryConfigDefaults(t *testing.T) {
+	c := RetryConfig{}.withDefaults()
+	assert.Equal(t, defaultInitialInterval, c.InitialInterval)
+	assert.Equal(t, defaultMaxInterval, c.MaxInterval)
+	assert.Equal(t, defaultMaxElapsedTime, c.MaxElapsedTime)
+	assert.Equal(t, defaultJitter, c.Jitter)
+
+	c = RetryConfig{Jitter: -1}.withDefaults()
+	assert.Equal(t, 0.0, c.Jitter)
+}
+
+func TestRetryConfigDo(t *testing.T) {
+	c := RetryConfig{
+		Enabled:         true,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		MaxElapsedTime:  time.Minute,
+	}.withDefaults()
+
+	var calls int
+	err := c.do(context.Background(), func(context.Context) error {
+		if calls++; calls < 3 {
+			return status.Error(codes.Unavailable, "")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	want := status.Error(codes.InvalidArgument, "")
+	err = c.do(context.Background(), func(context.Context) error {
+		calls++
+		return want
+	})
+	assert.ErrorIs(t, err, want)
+	assert.Equal(t, 1, calls, "non-retryable error retried")
+}
+
+func TestRetryConfigDoDisabled(t *testing.T) {
+	var calls int
+	err := RetryConfig{}.do(context.Background(), func(context.Context) error {
+		calls++
+		return status.Error(codes.Unavailable, "")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryConfigDoMaxElapsedTime(t *testing.T) {
+	c := RetryConfig{
+		Enabled:         true,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		MaxElapsedTime:  10 * time.Millisecond,
+		Jitter:          -1,
+	}.withDefaults()
+
+	want := status.Error(codes.Unavailable, "")
+	err := c.do(context.Background(), func(context.Context) error { return want })
+	assert.ErrorIs(t, err, want)
+	assert.ErrorContains(t, err, "max retry time elapsed")
+}
+
+func TestRetryConfigDoMaxElapsedTimeBlocked(t *testing.T) {
+	c := RetryConfig{
+		Enabled:        true,
+		MaxElapsedTime: 10 * time.Millisecond,
+	}.withDefaults()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.do(context.Background(), func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "attempt not bounded by max elapsed time")
+	}
+}
+
+func TestRetryConfigDoCanceled(t *testing.T) {
+	c := RetryConfig{Enabled: true}.withDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	want := status.Error(codes.Unavailable, "")
+	err := c.do(ctx, func(context.Context) error { return want })
+	assert.ErrorIs(t, err, want)
+	assert.ErrorIs(t, err, context.Canceled)
+}
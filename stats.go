@@ -0,0 +1,57 @@
+// Copyright 2022 Tyler Yahn (MrAlias)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlpr
+
+import (
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+)
+
+// Stats are cumulative counts describing the export of log records by a
+// logger.
+type Stats struct {
+	// RejectedLogRecords is the number of log records the receiving endpoint
+	// reported as rejected in partial success responses. Exports to a
+	// multi-destination Exporter (see NewMultiExporter) are delivered after
+	// the logger exports them, their rejected log records are only reported
+	// to the ErrorHandler of each Destination and are not counted.
+	RejectedLogRecords uint64
+	// DroppedLogRecords is the number of log records dropped because the
+	// export queue was full (see Batcher.QueuePolicy) or they were too large
+	// to export (see Batcher.MaxExportBytes).
+	DroppedLogRecords uint64
+}
+
+// Statistics returns the cumulative Stats of l. All loggers derived from the
+// same logger share the same Stats. A zero Stats is returned for loggers
+// whose sink is not from this package.
+func Statistics(l logr.Logger) Stats {
+	if ls, ok := l.GetSink().(*logSink); ok {
+		s := ls.stats.snapshot()
+		s.DroppedLogRecords = ls.batcher.Dropped()
+		return s
+	}
+	return Stats{}
+}
+
+// stats tracks the Stats of a logger.
+type stats struct {
+	rejected atomic.Uint64
+}
+
+func (s *stats) snapshot() Stats {
+	return Stats{RejectedLogRecords: s.rejected.Load()}
+}